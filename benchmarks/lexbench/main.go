@@ -0,0 +1,82 @@
+// Times the lexer on a large generated script, run it from the root of the repository:
+//
+//	go run ./benchmarks/lexbench -size 512
+package main
+
+import (
+	"QuonkScript/lexer"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+var (
+	size   = flag.Int("size", 512, "size of the generated script in KB")
+	runs   = flag.Int("runs", 5, "how many times to lex it")
+	source = flag.String("scripts", "benchmarks/*.qs", "scripts that are repeated to make up the generated script")
+	reader = flag.Bool("reader", false, "lex from an io.Reader instead of a string")
+)
+
+func main() {
+	flag.Parse()
+
+	src, err := generate(*source, *size*1024)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	tokens := 0
+	start := time.Now()
+	for i := 0; i < *runs; i++ {
+		if *reader {
+			tokens = countTokens(lexer.NewReader(strings.NewReader(src)))
+		} else {
+			tokens = len(lexer.Tokenize(src))
+		}
+	}
+	elapsed := time.Since(start) / time.Duration(*runs)
+
+	mb := float64(len(src)) / (1 << 20)
+	fmt.Printf("%d KB, %d tokens: %s per run, %.2f MB/s\n", len(src)/1024, tokens, elapsed, mb/elapsed.Seconds())
+}
+
+func countTokens(l *lexer.Lexer) int {
+	count := 1
+	for l.Next().Type != lexer.EOF {
+		count++
+	}
+	return count
+}
+
+// Repeats the scripts matching pattern until the result is at least size bytes
+func generate(pattern string, size int) (string, error) {
+	files, err := filepath.Glob(pattern)
+	if err != nil {
+		return "", err
+	}
+	if len(files) == 0 {
+		return "", fmt.Errorf("No scripts match %s", pattern)
+	}
+
+	var parts []string
+	for _, file := range files {
+		bytes, err := os.ReadFile(file)
+		if err != nil {
+			return "", err
+		}
+		parts = append(parts, string(bytes))
+	}
+
+	var src strings.Builder
+	for src.Len() < size {
+		for _, part := range parts {
+			src.WriteString(part)
+			src.WriteString("\n")
+		}
+	}
+	return src.String(), nil
+}
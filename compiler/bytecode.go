@@ -0,0 +1,220 @@
+package compiler
+
+import (
+	"QuonkScript/lexer"
+	"QuonkScript/parser"
+	"QuonkScript/runtime"
+	"fmt"
+	"sort"
+)
+
+type Opcode byte
+
+// Instructions are an opcode followed by its operands, which are big endian. Every instruction that leaves
+// a value leaves exactly one, so every statement leaves one value on the stack, the same value Evaluate returns for it
+const (
+	OpConstant Opcode = iota // [constant u16] pushes Constants[constant]
+	OpNull
+	OpTrue
+	OpFalse
+	OpPop
+
+	OpGetVar     // [name u16] pushes the variable Names[name]
+	OpDeclareVar // [name u16] [constant u8] declares the value on top of the stack, leaving it there
+	OpAssignVar  // [name u16] assigns the value on top of the stack, leaving it there
+
+	// Variables the resolver bound to a slot. Names[name] is only used in errors
+	OpGetLocal     // [depth u8] [slot u16] [name u16] pushes the slot of the scope depth levels up
+	OpDeclareLocal // [slot u16] [name u16] declares the value on top of the stack in the current scope, leaving it there
+	OpSetLocal     // [depth u8] [slot u16] [name u16] assigns the value on top of the stack, leaving it there
+
+	// Pop two operands and push the result
+	OpAdd
+	OpSubtract
+	OpMultiply
+	OpDivide
+	OpModulo
+	OpEqual
+	OpNotEqual
+	OpLess
+	OpLessEqual
+	OpGreater
+	OpGreaterEqual
+
+	// Pop one operand and push the result
+	OpNegate
+	OpPlus
+	OpNot
+
+	OpJump             // [target u16]
+	OpJumpIfFalse      // [target u16] pops the condition
+	OpJumpIfFalseOrPop // [target u16] for &&, keeps a falsy value and jumps, pops a truthy one
+	OpJumpIfTrueOrPop  // [target u16] for ||, keeps a truthy value and jumps, pops a falsy one
+
+	OpPushScope     // starts a block scope
+	OpPopScope      // ends it
+	OpNextIteration // replaces the scope of a for loop's header with a copy of it for the next iteration
+
+	OpArray       // [count u16] pops count elements and pushes an array of them
+	OpObject      // pushes an empty object
+	OpSetProperty // [name u16] pops a value and sets it on the object below it
+
+	// Member access is split up so that errors happen in the same order and at the same nodes as in Evaluate
+	OpCheckMember // [assign u8] checks that the value on top of the stack has members
+	OpCheckKey    // [computed u8] checks the key on top of the stack against the object below it
+	OpGetMember   // [computed u8] pops a key and an object and pushes the member
+	OpSetMember   // [computed u8] pops a value, a key and an object, sets the member and pushes the value
+
+	OpClosure  // [function u16] pushes Functions[function] as a function closing over the current scope
+	OpCall     // [args u8] pops a function and its arguments, which were pushed first, and pushes the result
+	OpTailCall // [args u8] like OpCall, but the current call returns the result
+	OpReturn   // returns the value on top of the stack
+
+	OpError // [message u16] raises Names[message]
+)
+
+var opcodeNames = map[Opcode]string{
+	OpConstant:         "CONSTANT",
+	OpNull:             "NULL",
+	OpTrue:             "TRUE",
+	OpFalse:            "FALSE",
+	OpPop:              "POP",
+	OpGetVar:           "GET_VAR",
+	OpDeclareVar:       "DECLARE_VAR",
+	OpAssignVar:        "ASSIGN_VAR",
+	OpGetLocal:         "GET_LOCAL",
+	OpDeclareLocal:     "DECLARE_LOCAL",
+	OpSetLocal:         "SET_LOCAL",
+	OpAdd:              "ADD",
+	OpSubtract:         "SUBTRACT",
+	OpMultiply:         "MULTIPLY",
+	OpDivide:           "DIVIDE",
+	OpModulo:           "MODULO",
+	OpEqual:            "EQUAL",
+	OpNotEqual:         "NOT_EQUAL",
+	OpLess:             "LESS",
+	OpLessEqual:        "LESS_EQUAL",
+	OpGreater:          "GREATER",
+	OpGreaterEqual:     "GREATER_EQUAL",
+	OpNegate:           "NEGATE",
+	OpPlus:             "PLUS",
+	OpNot:              "NOT",
+	OpJump:             "JUMP",
+	OpJumpIfFalse:      "JUMP_IF_FALSE",
+	OpJumpIfFalseOrPop: "JUMP_IF_FALSE_OR_POP",
+	OpJumpIfTrueOrPop:  "JUMP_IF_TRUE_OR_POP",
+	OpPushScope:        "PUSH_SCOPE",
+	OpPopScope:         "POP_SCOPE",
+	OpNextIteration:    "NEXT_ITERATION",
+	OpArray:            "ARRAY",
+	OpObject:           "OBJECT",
+	OpSetProperty:      "SET_PROPERTY",
+	OpCheckMember:      "CHECK_MEMBER",
+	OpCheckKey:         "CHECK_KEY",
+	OpGetMember:        "GET_MEMBER",
+	OpSetMember:        "SET_MEMBER",
+	OpClosure:          "CLOSURE",
+	OpCall:             "CALL",
+	OpTailCall:         "TAIL_CALL",
+	OpReturn:           "RETURN",
+	OpError:            "ERROR",
+}
+
+func (op Opcode) String() string {
+	if name, ok := opcodeNames[op]; ok {
+		return name
+	}
+	return fmt.Sprintf("Opcode(%d)", int(op))
+}
+
+// Sizes of the operands of each opcode in bytes, opcodes without operands are left out
+var operandWidths = map[Opcode][]int{
+	OpConstant:         {2},
+	OpGetVar:           {2},
+	OpDeclareVar:       {2, 1},
+	OpAssignVar:        {2},
+	OpGetLocal:         {1, 2, 2},
+	OpDeclareLocal:     {2, 2},
+	OpSetLocal:         {1, 2, 2},
+	OpJump:             {2},
+	OpJumpIfFalse:      {2},
+	OpJumpIfFalseOrPop: {2},
+	OpJumpIfTrueOrPop:  {2},
+	OpArray:            {2},
+	OpSetProperty:      {2},
+	OpCheckMember:      {1},
+	OpCheckKey:         {1},
+	OpGetMember:        {1},
+	OpSetMember:        {1},
+	OpClosure:          {2},
+	OpCall:             {1},
+	OpTailCall:         {1},
+	OpError:            {2},
+}
+
+// A compiled function, or the whole program, which is compiled as a function without parameters
+type Function struct {
+	Name      string
+	Params    []string
+	Slots     int           // see runtime.FunctionValue
+	Body      []parser.Stmt // the source of the function, see runtime.FunctionValue
+	Code      []byte
+	Constants []runtime.RuntimeValue
+	Names     []string    // names of variables and properties, and messages of OpError
+	Functions []*Function // functions declared inside of this one
+	Positions []Position  // where in the source each instruction came from, in order of Offset
+}
+
+type Position struct {
+	Offset int // of the instruction in Code
+	Pos    lexer.Position
+}
+
+// Source position of the instruction at offset
+func (f *Function) PositionAt(offset int) lexer.Position {
+	i := sort.Search(len(f.Positions), func(i int) bool { return f.Positions[i].Offset > offset })
+	if i == 0 {
+		return lexer.Position{}
+	}
+	return f.Positions[i-1].Pos
+}
+
+// Lists the instructions of the function and the functions inside of it, for debugging the compiler
+func (f *Function) Disassemble() string {
+	out := fmt.Sprintf("== %s ==\n", f.Name)
+	for offset := 0; offset < len(f.Code); {
+		op := Opcode(f.Code[offset])
+		out += fmt.Sprintf("%04d %-4s %s", offset, f.PositionAt(offset), op)
+
+		next := offset + 1
+		for _, width := range operandWidths[op] {
+			operand := ReadOperand(f.Code, next, width)
+			out += fmt.Sprintf(" %d", operand)
+			next += width
+		}
+		switch op {
+		case OpConstant:
+			out += fmt.Sprintf(" (%v)", f.Constants[ReadOperand(f.Code, offset+1, 2)])
+		case OpGetVar, OpDeclareVar, OpAssignVar, OpSetProperty, OpError:
+			out += fmt.Sprintf(" (%s)", f.Names[ReadOperand(f.Code, offset+1, 2)])
+		case OpGetLocal, OpDeclareLocal, OpSetLocal:
+			// The name is the last operand
+			out += fmt.Sprintf(" (%s)", f.Names[ReadOperand(f.Code, next-2, 2)])
+		}
+		out += "\n"
+		offset = next
+	}
+
+	for _, function := range f.Functions {
+		out += "\n" + function.Disassemble()
+	}
+	return out
+}
+
+// Reads a big endian operand of width bytes at offset
+func ReadOperand(code []byte, offset int, width int) int {
+	if width == 1 {
+		return int(code[offset])
+	}
+	return int(code[offset])<<8 | int(code[offset+1])
+}
@@ -0,0 +1,591 @@
+// Package compiler lowers the AST to bytecode for the vm package
+package compiler
+
+import (
+	"QuonkScript/lexer"
+	"QuonkScript/parser"
+	"QuonkScript/runtime"
+	"fmt"
+)
+
+// CompileError is returned for programs that cannot be compiled, such as ones with a break outside of a loop or
+// a function too large for the jumps inside of it. Evaluate only raises the first kind once it gets to them
+type CompileError struct {
+	Message string
+	Pos     lexer.Position
+}
+
+func (e CompileError) Error() string {
+	return fmt.Sprintf("Honk! %s: %s", e.Pos, e.Message)
+}
+
+// Name of the function the program is compiled to, the same name tracebacks use for the top level
+const mainFunctionName = "<main>"
+
+// Most operands are 16 bits, argument counts and the depths of local variables are 8
+const (
+	maxOperand = 1<<16 - 1
+	maxArgs    = 1<<8 - 1
+	maxDepth   = 1<<8 - 1
+)
+
+type Compiler struct {
+	function *Function
+	names    map[string]int // index of each name in function.Names
+	strings  map[string]int // index of each string constant
+	numbers  map[float64]int
+	integers map[int64]int
+	scopes   int // block scopes that are open in the function
+	loops    []*loop
+}
+
+// Jumps out of a loop that are patched once the loop is compiled
+type loop struct {
+	scopes    int   // block scopes that were open outside of the loop body
+	breaks    []int // offsets of the operands of break jumps
+	continues []int
+}
+
+// Compiles a program to a function without parameters. Running it evaluates the program in the scope it is run in
+// and returns the value of the last statement, like runtime.Evaluate
+func Compile(program parser.Program) (function *Function, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			compileErr, ok := r.(CompileError)
+			if !ok {
+				panic(r)
+			}
+			function, err = nil, compileErr
+		}
+	}()
+
+	c := newCompiler(mainFunctionName, nil, program.Body)
+	c.compileBody(program.Body, program.Pos)
+	c.emit(OpReturn, program.End)
+
+	return c.function, nil
+}
+
+func newCompiler(name string, params []string, body []parser.Stmt) *Compiler {
+	return &Compiler{
+		function: &Function{Name: name, Params: params, Body: body},
+		names:    make(map[string]int),
+		strings:  make(map[string]int),
+		numbers:  make(map[float64]int),
+		integers: make(map[int64]int),
+	}
+}
+
+func (c *Compiler) fail(pos lexer.Position, format string, args ...any) {
+	panic(CompileError{Message: fmt.Sprintf(format, args...), Pos: pos})
+}
+
+// Emitting
+
+// Appends an instruction that came from the source at pos and returns its offset
+func (c *Compiler) emit(op Opcode, pos lexer.Position, operands ...int) int {
+	f := c.function
+	offset := len(f.Code)
+	f.Positions = append(f.Positions, Position{Offset: offset, Pos: pos})
+
+	f.Code = append(f.Code, byte(op))
+	for i, width := range operandWidths[op] {
+		operand := operands[i]
+		if width == 1 {
+			f.Code = append(f.Code, byte(operand))
+		} else {
+			f.Code = append(f.Code, byte(operand>>8), byte(operand))
+		}
+	}
+	return offset
+}
+
+// Emits a jump whose target is patched later and returns the offset of its operand
+func (c *Compiler) emitJump(op Opcode, pos lexer.Position) int {
+	return c.emit(op, pos, 0) + 1
+}
+
+// Points the jump with its operand at operand to the next instruction
+func (c *Compiler) patchJump(operand int) {
+	c.patchJumpTo(operand, len(c.function.Code))
+}
+
+func (c *Compiler) patchJumpTo(operand int, target int) {
+	if target > maxOperand {
+		c.fail(c.function.PositionAt(operand-1), "Function %s is too large to compile", c.function.Name)
+	}
+	c.function.Code[operand] = byte(target >> 8)
+	c.function.Code[operand+1] = byte(target)
+}
+
+func (c *Compiler) name(name string, pos lexer.Position) int {
+	if index, ok := c.names[name]; ok {
+		return index
+	}
+	c.function.Names = append(c.function.Names, name)
+	index := c.checkIndex(len(c.function.Names)-1, pos)
+	c.names[name] = index
+	return index
+}
+
+func (c *Compiler) constant(value runtime.RuntimeValue, pos lexer.Position) int {
+	// Identical strings and numbers share a constant
+	switch value := value.(type) {
+	case runtime.StringValue:
+		if index, ok := c.strings[value.Value]; ok {
+			return index
+		}
+	case runtime.NumberValue:
+		if index, ok := c.numbers[value.Value]; ok {
+			return index
+		}
+	case runtime.IntegerValue:
+		if index, ok := c.integers[value.Value]; ok {
+			return index
+		}
+	}
+
+	c.function.Constants = append(c.function.Constants, value)
+	index := c.checkIndex(len(c.function.Constants)-1, pos)
+	switch value := value.(type) {
+	case runtime.StringValue:
+		c.strings[value.Value] = index
+	case runtime.NumberValue:
+		c.numbers[value.Value] = index
+	case runtime.IntegerValue:
+		c.integers[value.Value] = index
+	}
+	return index
+}
+
+func (c *Compiler) checkIndex(index int, pos lexer.Position) int {
+	if index > maxOperand {
+		c.fail(pos, "Function %s has too many constants to compile", c.function.Name)
+	}
+	return index
+}
+
+// Variables the resolver bound to a slot are found by index, the rest by name
+
+func (c *Compiler) emitGet(binding parser.Binding, name string, pos lexer.Position) {
+	if binding.Local {
+		c.emit(OpGetLocal, pos, c.depth(binding, pos), binding.Slot, c.name(name, pos))
+		return
+	}
+	c.emit(OpGetVar, pos, c.name(name, pos))
+}
+
+func (c *Compiler) emitDeclare(binding parser.Binding, name string, constant bool, pos lexer.Position) {
+	if binding.Local {
+		c.emit(OpDeclareLocal, pos, c.checkIndex(binding.Slot, pos), c.name(name, pos))
+		return
+	}
+	c.emit(OpDeclareVar, pos, c.name(name, pos), boolOperand(constant))
+}
+
+// Checks that the operands of a local variable fit, and returns its depth
+func (c *Compiler) depth(binding parser.Binding, pos lexer.Position) int {
+	if binding.Depth > maxDepth {
+		c.fail(pos, "Function %s nests scopes too deeply to compile", c.function.Name)
+	}
+	c.checkIndex(binding.Slot, pos)
+	return binding.Depth
+}
+
+func boolOperand(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// Statements
+
+// Compiles statements so that they leave the value of the last one, or null at pos if there are none
+func (c *Compiler) compileBody(body []parser.Stmt, pos lexer.Position) {
+	if len(body) == 0 {
+		c.emit(OpNull, pos)
+		return
+	}
+
+	for i, stmt := range body {
+		if i > 0 {
+			c.emit(OpPop, stmt.GetPos())
+		}
+		c.compileStmt(stmt)
+	}
+}
+
+// Like compileBody, but in a scope of its own
+func (c *Compiler) compileBlock(body []parser.Stmt, pos lexer.Position) {
+	c.emit(OpPushScope, pos)
+	c.scopes++
+	c.compileBody(body, pos)
+	c.scopes--
+	c.emit(OpPopScope, pos)
+}
+
+func (c *Compiler) compileStmt(stmt parser.Stmt) {
+	switch stmt.GetKind() {
+	case parser.VarDeclarationNode:
+		c.compileVarDeclaration(stmt.(parser.VarDeclaration))
+	case parser.FunctionDeclarationNode:
+		declaration := stmt.(parser.FunctionDeclaration)
+		c.compileFunction(declaration.Name, declaration.Params, declaration.Body, declaration.Slots, declaration.Pos)
+		c.emitDeclare(declaration.Binding, declaration.Name, true, declaration.Pos)
+	case parser.BranchNode:
+		c.compileBranch(stmt.(parser.BranchStmt))
+	case parser.ReturnNode:
+		c.compileReturn(stmt.(parser.ReturnStmt))
+	case parser.WhileNode:
+		c.compileWhile(stmt.(parser.WhileStmt))
+	case parser.ForNode:
+		c.compileFor(stmt.(parser.ForStmt))
+	case parser.BreakNode, parser.ContinueNode:
+		c.compileLoopControl(stmt)
+	case parser.ProgramNode:
+		c.fail(stmt.GetPos(), "Programs cannot be nested")
+	default:
+		c.compileExpr(stmt.(parser.Expr))
+	}
+}
+
+func (c *Compiler) compileVarDeclaration(declaration parser.VarDeclaration) {
+	if declaration.Value == nil {
+		c.emit(OpNull, declaration.Pos)
+	} else {
+		c.compileExpr(*declaration.Value)
+	}
+	c.emitDeclare(declaration.Binding, declaration.Identifier, declaration.Constant, declaration.Pos)
+}
+
+func (c *Compiler) compileBranch(stmt parser.BranchStmt) {
+	c.compileExpr(stmt.Condition)
+	elseJump := c.emitJump(OpJumpIfFalse, stmt.Pos)
+
+	c.compileBlock(stmt.Body, stmt.Pos)
+	endJump := c.emitJump(OpJump, stmt.Pos)
+
+	c.patchJump(elseJump)
+	c.compileBlock(stmt.Else, stmt.Pos)
+	c.patchJump(endJump)
+}
+
+func (c *Compiler) compileReturn(stmt parser.ReturnStmt) {
+	if c.function.Name == mainFunctionName {
+		c.fail(stmt.Pos, "Cannot return outside of a function")
+	}
+
+	if stmt.Value == nil {
+		c.emit(OpNull, stmt.Pos)
+		c.emit(OpReturn, stmt.Pos)
+		return
+	}
+
+	if call, ok := (*stmt.Value).(parser.InternalFunctionCallExpr); ok {
+		c.compileCall(call, OpTailCall)
+		return
+	}
+	c.compileExpr(*stmt.Value)
+	c.emit(OpReturn, stmt.Pos)
+}
+
+// Loops leave null, like the loops of Evaluate
+func (c *Compiler) compileWhile(stmt parser.WhileStmt) {
+	l := c.startLoop()
+	start := len(c.function.Code)
+
+	c.compileExpr(stmt.Condition)
+	exitJump := c.emitJump(OpJumpIfFalse, stmt.Pos)
+	c.compileLoopBody(stmt.Body, stmt.Pos)
+	c.patchJumpTo(c.emitJump(OpJump, stmt.Pos), start)
+
+	for _, operand := range l.continues {
+		c.patchJumpTo(operand, start)
+	}
+	c.patchJump(exitJump)
+	c.endLoop(l)
+	c.emit(OpNull, stmt.Pos)
+}
+
+func (c *Compiler) compileFor(stmt parser.ForStmt) {
+	// The header gets a scope around the loop that is copied for every iteration, like in evalForStmt
+	c.emit(OpPushScope, stmt.Pos)
+	c.scopes++
+
+	if stmt.Init != nil {
+		c.compileStmt(*stmt.Init)
+		c.emit(OpPop, stmt.Pos)
+	}
+
+	l := c.startLoop()
+	start := len(c.function.Code)
+	exitJump := -1
+	if stmt.Condition != nil {
+		c.compileExpr(*stmt.Condition)
+		exitJump = c.emitJump(OpJumpIfFalse, stmt.Pos)
+	}
+	c.compileLoopBody(stmt.Body, stmt.Pos)
+
+	for _, operand := range l.continues {
+		c.patchJump(operand)
+	}
+	c.emit(OpNextIteration, stmt.Pos)
+	if stmt.Update != nil {
+		c.compileExpr(*stmt.Update)
+		c.emit(OpPop, stmt.Pos)
+	}
+	c.patchJumpTo(c.emitJump(OpJump, stmt.Pos), start)
+
+	if exitJump != -1 {
+		c.patchJump(exitJump)
+	}
+	c.endLoop(l)
+
+	c.scopes--
+	c.emit(OpPopScope, stmt.Pos)
+	c.emit(OpNull, stmt.Pos)
+}
+
+func (c *Compiler) startLoop() *loop {
+	l := &loop{scopes: c.scopes}
+	c.loops = append(c.loops, l)
+	return l
+}
+
+// Points breaks at the next instruction
+func (c *Compiler) endLoop(l *loop) {
+	for _, operand := range l.breaks {
+		c.patchJump(operand)
+	}
+	c.loops = c.loops[:len(c.loops)-1]
+}
+
+// Each iteration gets a fresh scope, and the value of the body is thrown away
+func (c *Compiler) compileLoopBody(body []parser.Stmt, pos lexer.Position) {
+	c.compileBlock(body, pos)
+	c.emit(OpPop, pos)
+}
+
+// break and continue close the scopes opened inside of the loop and jump out of the body
+func (c *Compiler) compileLoopControl(stmt parser.Stmt) {
+	if len(c.loops) == 0 {
+		if stmt.GetKind() == parser.BreakNode {
+			c.fail(stmt.GetPos(), "Cannot break outside of a loop")
+		}
+		c.fail(stmt.GetPos(), "Cannot continue outside of a loop")
+	}
+
+	l := c.loops[len(c.loops)-1]
+	for i := l.scopes; i < c.scopes; i++ {
+		c.emit(OpPopScope, stmt.GetPos())
+	}
+
+	jump := c.emitJump(OpJump, stmt.GetPos())
+	if stmt.GetKind() == parser.BreakNode {
+		l.breaks = append(l.breaks, jump)
+	} else {
+		l.continues = append(l.continues, jump)
+	}
+}
+
+// Functions
+
+// Compiles a function and emits the instruction that creates it. Jumps and loops do not cross functions,
+// so the body gets a compiler of its own
+func (c *Compiler) compileFunction(name string, params []string, body []parser.Stmt, slots int, pos lexer.Position) {
+	inner := newCompiler(name, params, body)
+	inner.function.Slots = slots
+
+	// The last statement is in tail position
+	if len(body) == 0 {
+		inner.emit(OpNull, pos)
+	}
+	for i, stmt := range body {
+		if i > 0 {
+			inner.emit(OpPop, stmt.GetPos())
+		}
+		if call, ok := stmt.(parser.InternalFunctionCallExpr); ok && i == len(body)-1 {
+			inner.compileCall(call, OpTailCall)
+		} else {
+			inner.compileStmt(stmt)
+		}
+	}
+	inner.emit(OpReturn, pos)
+
+	c.function.Functions = append(c.function.Functions, inner.function)
+	c.emit(OpClosure, pos, c.checkIndex(len(c.function.Functions)-1, pos))
+}
+
+func (c *Compiler) compileCall(call parser.InternalFunctionCallExpr, op Opcode) {
+	if len(call.Args) > maxArgs {
+		c.fail(call.Pos, "Cannot call a function with more than %d arguments", maxArgs)
+	}
+
+	// Arguments are evaluated before the function, like evalCallExpr does
+	for _, arg := range call.Args {
+		c.compileExpr(arg)
+	}
+	c.compileExpr(call.Caller)
+	c.emit(op, call.Pos, len(call.Args))
+}
+
+// Expressions
+
+var binaryOps = map[string]Opcode{
+	"+": OpAdd,
+	"-": OpSubtract,
+	"*": OpMultiply,
+	"/": OpDivide,
+	"%": OpModulo,
+}
+
+var comparisonOps = map[string]Opcode{
+	"==": OpEqual,
+	"!=": OpNotEqual,
+	"<":  OpLess,
+	"<=": OpLessEqual,
+	">":  OpGreater,
+	">=": OpGreaterEqual,
+}
+
+var unaryOps = map[string]Opcode{
+	"-": OpNegate,
+	"+": OpPlus,
+	"!": OpNot,
+}
+
+func (c *Compiler) compileExpr(expr parser.Expr) {
+	switch expr.GetKind() {
+	case parser.NumericLiteralNode:
+		literal := expr.(parser.NumericLiteral)
+		c.emit(OpConstant, literal.Pos, c.constant(runtime.MakeNumber(literal.Value), literal.Pos))
+	case parser.IntegerLiteralNode:
+		literal := expr.(parser.IntegerLiteral)
+		c.emit(OpConstant, literal.Pos, c.constant(runtime.MakeInteger(literal.Value), literal.Pos))
+	case parser.StringLiteralNode:
+		literal := expr.(parser.StringLiteral)
+		c.emit(OpConstant, literal.Pos, c.constant(runtime.MakeString(literal.Value), literal.Pos))
+	case parser.NullLiteralNode:
+		c.emit(OpNull, expr.GetPos())
+	case parser.BooleanLiteralNode:
+		if expr.(parser.BooleanLiteral).Value {
+			c.emit(OpTrue, expr.GetPos())
+		} else {
+			c.emit(OpFalse, expr.GetPos())
+		}
+	case parser.IdentifierNode:
+		ident := expr.(parser.Ident)
+		c.emitGet(ident.Binding, ident.Symbol, ident.Pos)
+	case parser.BinaryExprNode:
+		binary := expr.(parser.BinaryExpr)
+		c.compileOperator(binaryOps, binary.Operator, binary.Pos, binary.Left, binary.Right)
+	case parser.ComparisonExprNode:
+		comparison := expr.(parser.ComparisonExpr)
+		c.compileOperator(comparisonOps, comparison.Operator, comparison.Pos, comparison.Left, comparison.Right)
+	case parser.UnaryExprNode:
+		unary := expr.(parser.UnaryExpr)
+		c.compileOperator(unaryOps, unary.Operator, unary.Pos, unary.Operand)
+	case parser.LogicalExprNode:
+		c.compileLogical(expr.(parser.LogicalExpr))
+	case parser.AssignmentExprNode:
+		c.compileAssignment(expr.(parser.VarAssignmentExpr))
+	case parser.ObjectLiteralNode:
+		c.compileObject(expr.(parser.ObjectLiteral))
+	case parser.ArrayLiteralNode:
+		array := expr.(parser.ArrayLiteral)
+		if len(array.Elements) > maxOperand {
+			c.fail(array.Pos, "Array literal has too many elements to compile")
+		}
+		for _, element := range array.Elements {
+			c.compileExpr(element)
+		}
+		c.emit(OpArray, array.Pos, len(array.Elements))
+	case parser.MemberExprNode:
+		member := expr.(parser.MemberExpr)
+		c.compileExpr(member.Object)
+		c.emit(OpCheckMember, member.Object.GetPos(), 0)
+		c.compileMemberKey(member)
+		c.emit(OpGetMember, member.Field.GetPos(), boolOperand(member.Computed))
+	case parser.InternalFunctionCallExprNode:
+		c.compileCall(expr.(parser.InternalFunctionCallExpr), OpCall)
+	case parser.FunctionExprNode:
+		function := expr.(parser.FunctionExpr)
+		c.compileFunction(runtime.AnonymousFunctionName, function.Params, function.Body, function.Slots, function.Pos)
+	default:
+		c.fail(expr.GetPos(), "Cannot compile node of kind %d", expr.GetKind())
+	}
+}
+
+// Compiles the operands in order, then the operator
+func (c *Compiler) compileOperator(ops map[string]Opcode, operator string, pos lexer.Position, operands ...parser.Expr) {
+	op, ok := ops[operator]
+	if !ok {
+		c.fail(pos, "Unknown operator %s", operator)
+	}
+
+	for _, operand := range operands {
+		c.compileExpr(operand)
+	}
+	c.emit(op, pos)
+}
+
+func (c *Compiler) compileLogical(expr parser.LogicalExpr) {
+	c.compileExpr(expr.Left)
+
+	op := OpJumpIfTrueOrPop
+	if expr.Operator == "&&" {
+		op = OpJumpIfFalseOrPop
+	}
+	end := c.emitJump(op, expr.Pos)
+	c.compileExpr(expr.Right)
+	c.patchJump(end)
+}
+
+func (c *Compiler) compileAssignment(expr parser.VarAssignmentExpr) {
+	switch assignee := expr.Assignee.(type) {
+	case parser.Ident:
+		c.compileExpr(expr.Value)
+		if assignee.Binding.Local {
+			c.emit(OpSetLocal, expr.Pos, c.depth(assignee.Binding, expr.Pos), assignee.Binding.Slot, c.name(assignee.Symbol, expr.Pos))
+		} else {
+			c.emit(OpAssignVar, expr.Pos, c.name(assignee.Symbol, expr.Pos))
+		}
+	case parser.MemberExpr:
+		c.compileExpr(assignee.Object)
+		c.emit(OpCheckMember, assignee.Object.GetPos(), 1)
+		c.compileMemberKey(assignee)
+		// The key is checked before the value is evaluated
+		c.emit(OpCheckKey, assignee.Field.GetPos(), boolOperand(assignee.Computed))
+		c.compileExpr(expr.Value)
+		c.emit(OpSetMember, assignee.Field.GetPos(), boolOperand(assignee.Computed))
+	default:
+		// Evaluate raises this when it gets to the assignment, so the program does too
+		message := c.name("Attempt to assign value to something other than an identifier or member", expr.Assignee.GetPos())
+		c.emit(OpError, expr.Assignee.GetPos(), message)
+	}
+}
+
+// The key of obj.field is its name as a string
+func (c *Compiler) compileMemberKey(member parser.MemberExpr) {
+	if member.Computed {
+		c.compileExpr(member.Field)
+		return
+	}
+	// The parser only allows identifiers after a dot
+	name := member.Field.(parser.Ident).Symbol
+	c.emit(OpConstant, member.Field.GetPos(), c.constant(runtime.MakeString(name), member.Field.GetPos()))
+}
+
+func (c *Compiler) compileObject(object parser.ObjectLiteral) {
+	c.emit(OpObject, object.Pos)
+	for _, property := range object.Properties {
+		if property.Value == nil {
+			// { key } is short for { key: key }
+			c.emitGet(property.Binding, property.Key, property.Pos)
+		} else {
+			c.compileExpr(*property.Value)
+		}
+		c.emit(OpSetProperty, property.Pos, c.name(property.Key, property.Pos))
+	}
+}
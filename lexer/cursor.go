@@ -0,0 +1,87 @@
+package lexer
+
+import (
+	"io"
+	"unicode/utf8"
+)
+
+// How much of the source is read at a time when lexing from an io.Reader
+const chunkSize = 64 * 1024
+
+// cursor walks the source one character at a time and keeps track of where in the source it is.
+// A character is a UTF-8 encoded rune, or a single byte that is not valid UTF-8
+type cursor struct {
+	buf []byte    // the source, or the part of it that has been read but not lexed yet
+	i   int       // offset of the current character in buf
+	r   io.Reader // where the rest of the source is read from, nil once buf holds all of it
+	err error     // error that stopped reading from r
+	pos Position
+}
+
+func newCursor(src []byte, r io.Reader) cursor {
+	return cursor{buf: src, r: r, pos: Position{Offset: 0, Line: 1, Column: 1}}
+}
+
+// Makes sure the whole of the current character is in buf, reading more of the source if needed
+func (c *cursor) fill() {
+	for c.r != nil && len(c.buf)-c.i < utf8.UTFMax {
+		// Keep what is left and read the next chunk after it
+		n := copy(c.buf, c.buf[c.i:])
+		c.i = 0
+		if cap(c.buf) < n+chunkSize {
+			buf := make([]byte, n, n+chunkSize)
+			copy(buf, c.buf[:n])
+			c.buf = buf
+		}
+
+		read, err := c.r.Read(c.buf[n : n+chunkSize])
+		c.buf = c.buf[:n+read]
+		if err != nil {
+			if err != io.EOF {
+				c.err = err
+			}
+			c.r = nil
+		}
+	}
+}
+
+func (c *cursor) remaining() bool {
+	c.fill()
+	return c.i < len(c.buf)
+}
+
+// returns the first byte of the current character, which is the whole character if it is ASCII.
+// Must only be called if remaining is true
+func (c *cursor) at() byte {
+	return c.buf[c.i]
+}
+
+// Whether there is a current character and it is char
+func (c *cursor) is(char byte) bool {
+	return c.remaining() && c.buf[c.i] == char
+}
+
+// returns the bytes of the current character
+func (c *cursor) char() []byte {
+	if c.buf[c.i] < utf8.RuneSelf {
+		return c.buf[c.i : c.i+1]
+	}
+	_, size := utf8.DecodeRune(c.buf[c.i:])
+	return c.buf[c.i : c.i+size]
+}
+
+// advances the position past the current character and returns it. The returned bytes are only valid until the next call
+func (c *cursor) advance() []byte {
+	char := c.char()
+	c.i += len(char)
+
+	c.pos.Offset += len(char)
+	if char[0] == '\n' {
+		c.pos.Line++
+		c.pos.Column = 1
+	} else {
+		c.pos.Column++
+	}
+
+	return char
+}
@@ -0,0 +1,488 @@
+// Package baseline is the lexer as it was before it was rewritten around a byte cursor. It only exists so that
+// BenchmarkBaselineTokenize can show how the current lexer compares, and is kept exactly as it was apart from its
+// package name, so fixes made to the lexer since then are not in it
+package baseline
+
+import (
+	"QuonkScript/utils"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+type TokenType int
+
+const (
+	// Literals
+	Null TokenType = iota + 1
+	Number
+	Identifier
+	String
+
+	// Keywords
+	Mut
+	Const
+	True
+	False
+	If
+	Else
+	Elseif
+	Func
+	Return
+	While
+	For
+	Break
+	Continue
+
+	// Grouping and operations
+	Equals
+	Semicolon
+	OpenParen
+	CloseParen
+	BinaryOperator
+	OpenCurlyBracket
+	CloseCurlyBracket
+	Comma
+	Colon
+	OpenSquareBracket
+	CloseSquareBracket
+	Dot
+	Equality
+	GreaterThan
+	LessThan
+	GreaterEqualTo
+	LessEqualTo
+	NotEqual
+	And
+	Or
+	Bang
+	Arrow
+
+	Illegal // Unrecognized character, reported by the parser
+	EOF     // End of File
+)
+
+var tokenNames = map[TokenType]string{
+	Null:               "null",
+	Number:             "number",
+	Identifier:         "identifier",
+	String:             "string",
+	Mut:                "mut",
+	Const:              "const",
+	True:               "true",
+	False:              "false",
+	If:                 "if",
+	Else:               "else",
+	Elseif:             "elseif",
+	Func:               "func",
+	Return:             "return",
+	While:              "while",
+	For:                "for",
+	Break:              "break",
+	Continue:           "continue",
+	Equals:             "=",
+	Semicolon:          ";",
+	OpenParen:          "(",
+	CloseParen:         ")",
+	BinaryOperator:     "operator",
+	OpenCurlyBracket:   "{",
+	CloseCurlyBracket:  "}",
+	Comma:              ",",
+	Colon:              ":",
+	OpenSquareBracket:  "[",
+	CloseSquareBracket: "]",
+	Dot:                ".",
+	Equality:           "==",
+	GreaterThan:        ">",
+	LessThan:           "<",
+	GreaterEqualTo:     ">=",
+	LessEqualTo:        "<=",
+	NotEqual:           "!=",
+	And:                "&&",
+	Or:                 "||",
+	Bang:               "!",
+	Arrow:              "=>",
+	Illegal:            "illegal character",
+	EOF:                "end of file",
+}
+
+func (t TokenType) String() string {
+	if name, ok := tokenNames[t]; ok {
+		return name
+	}
+	return fmt.Sprintf("TokenType(%d)", int(t))
+}
+
+const (
+	leftParen          = "("
+	rightParen         = ")"
+	addSym             = "+"
+	multSym            = "*"
+	divSym             = "/"
+	subSym             = "-"
+	eqSym              = "="
+	modSym             = "%"
+	semi               = ";"
+	leftCurlyBracket   = "{"
+	rightCurlyBracket  = "}"
+	comma              = ","
+	colon              = ":"
+	leftSquareBracket  = "["
+	rightSquareBracket = "]"
+	dot                = "."
+	greaterThan        = ">"
+	lessThan           = "<"
+	equality           = "=="
+	leq                = "<="
+	geq                = ">="
+	bang               = "!"
+	ampersand          = "&"
+	pipe               = "|"
+	doubleQuote        = "\""
+	singleQuote        = "'"
+	backslash          = "\\"
+)
+
+// Position is a location in the source. Line and Column are 1-based and Column
+// counts characters, Offset is the 0-based byte offset into the source.
+type Position struct {
+	Offset int
+	Line   int
+	Column int
+}
+
+func (p Position) String() string {
+	return fmt.Sprintf("%d:%d", p.Line, p.Column)
+}
+
+// Positions are marshalled as line:column to keep PrintAST output readable
+func (p Position) MarshalText() ([]byte, error) {
+	return []byte(p.String()), nil
+}
+
+type Token struct {
+	Value string
+	Type  TokenType
+	Pos   Position // position of the first character of the token
+	End   Position // position immediately after the last character of the token
+	Error string   // explains what is wrong with an Illegal token, empty for an unrecognized character
+}
+
+func isAlpha(s string) bool {
+	return regexp.MustCompile(`^[a-zA-Z]+$`).MatchString(s)
+}
+
+func isNumeric(s string) bool {
+	return regexp.MustCompile(`^[0-9]+$`).MatchString(s)
+}
+
+func isSkipable(s string) bool {
+	return s == " " || s == "\n" || s == "\t" || s == "\r"
+}
+
+func token(Type TokenType, Value string, pos Position, end Position) Token {
+	return Token{Type: Type, Value: Value, Pos: pos, End: end}
+}
+
+// cursor walks the split source and keeps track of where in the source it is
+type cursor struct {
+	src []string
+	pos Position
+}
+
+// returns first character in src
+func (c *cursor) at() string {
+	return c.src[0]
+}
+
+// removes first character from src, advances the position past it and returns it
+func (c *cursor) advance() string {
+	char := c.src[0]
+	c.src = utils.Pop(c.src)
+
+	c.pos.Offset += len(char)
+	if char == "\n" {
+		c.pos.Line++
+		c.pos.Column = 1
+	} else {
+		c.pos.Column++
+	}
+
+	return char
+}
+
+func (c *cursor) remaining() int {
+	return len(c.src)
+}
+
+func getKeywordMap() map[string]TokenType {
+	return map[string]TokenType{
+		"mut":      Mut,
+		"const":    Const,
+		"null":     Null,
+		"true":     True,
+		"false":    False,
+		"if":       If,
+		"else":     Else,
+		"elseif":   Elseif,
+		"func":     Func,
+		"return":   Return,
+		"while":    While,
+		"for":      For,
+		"break":    Break,
+		"continue": Continue,
+	}
+}
+
+func Tokenize(source string) []Token {
+	tokens := make([]Token, 0)
+	keywords := getKeywordMap()
+
+	c := &cursor{src: strings.Split(source, ""), pos: Position{Offset: 0, Line: 1, Column: 1}}
+
+	// Build each token
+	for c.remaining() > 0 {
+
+		// c.at() will always be defined because c.remaining() > 0
+		char := c.at()
+		// every token starts where the cursor currently is
+		start := c.pos
+
+		switch char {
+		case leftParen:
+			// Pop first char
+			c.advance()
+			tokens = append(tokens, token(OpenParen, char, start, c.pos))
+
+		case rightParen:
+			c.advance()
+			tokens = append(tokens, token(CloseParen, char, start, c.pos))
+
+		case leftCurlyBracket:
+			c.advance()
+			tokens = append(tokens, token(OpenCurlyBracket, char, start, c.pos))
+
+		case rightCurlyBracket:
+			c.advance()
+			tokens = append(tokens, token(CloseCurlyBracket, char, start, c.pos))
+
+		case leftSquareBracket:
+			c.advance()
+			tokens = append(tokens, token(OpenSquareBracket, char, start, c.pos))
+		case rightSquareBracket:
+			c.advance()
+			tokens = append(tokens, token(CloseSquareBracket, char, start, c.pos))
+		case addSym:
+			fallthrough
+		case subSym:
+			fallthrough
+		case divSym:
+			fallthrough
+		case modSym:
+			fallthrough
+		case multSym:
+			c.advance()
+			tokens = append(tokens, token(BinaryOperator, char, start, c.pos))
+		case eqSym:
+			// check for equality symbol here
+			c.advance()
+			if c.remaining() > 0 && c.at() == eqSym { // another equals sign
+				c.advance()
+				tokens = append(tokens, token(Equality, "==", start, c.pos))
+			} else if c.remaining() > 0 && c.at() == greaterThan { // arrow function
+				c.advance()
+				tokens = append(tokens, token(Arrow, "=>", start, c.pos))
+			} else {
+				tokens = append(tokens, token(Equals, char, start, c.pos))
+			}
+		case greaterThan: // >= or >
+			// need to check next char
+			c.advance()
+			if c.remaining() > 0 && c.at() == eqSym { // looking for equals sign
+				c.advance()
+				tokens = append(tokens, token(GreaterEqualTo, ">=", start, c.pos))
+			} else {
+				tokens = append(tokens, token(GreaterThan, char, start, c.pos))
+			}
+		case lessThan: // <= or <
+			// need to check next char
+			c.advance()
+			if c.remaining() > 0 && c.at() == eqSym { // looking for equals sign
+				c.advance()
+				tokens = append(tokens, token(LessEqualTo, "<=", start, c.pos))
+			} else {
+				tokens = append(tokens, token(LessThan, char, start, c.pos))
+			}
+		case bang:
+			// need to check next char
+			c.advance()
+			if c.remaining() > 0 && c.at() == eqSym { // looking for equals sign
+				c.advance()
+				tokens = append(tokens, token(NotEqual, "!=", start, c.pos))
+			} else {
+				tokens = append(tokens, token(Bang, char, start, c.pos))
+			}
+		case ampersand:
+			c.advance()
+			if c.remaining() > 0 && c.at() == ampersand {
+				c.advance()
+				tokens = append(tokens, token(And, "&&", start, c.pos))
+			} else {
+				tokens = append(tokens, token(Illegal, char, start, c.pos))
+			}
+		case pipe:
+			c.advance()
+			if c.remaining() > 0 && c.at() == pipe {
+				c.advance()
+				tokens = append(tokens, token(Or, "||", start, c.pos))
+			} else {
+				tokens = append(tokens, token(Illegal, char, start, c.pos))
+			}
+		case semi:
+			c.advance()
+			tokens = append(tokens, token(Semicolon, char, start, c.pos))
+		case colon:
+			c.advance()
+			tokens = append(tokens, token(Colon, char, start, c.pos))
+		case comma:
+			c.advance()
+			tokens = append(tokens, token(Comma, char, start, c.pos))
+		case dot:
+			c.advance()
+			tokens = append(tokens, token(Dot, char, start, c.pos))
+		case doubleQuote:
+			fallthrough
+		case singleQuote:
+			tokens = append(tokens, lexString(c))
+		default:
+			// Handle multichar token
+			if isNumeric(char) {
+				num := ""
+				// while there are characters left to Parse and the characters are numeric
+				// We don't use char here because we want to process entire multichar number within this switch case
+				for c.remaining() > 0 && isNumeric(c.at()) {
+					num += c.advance()
+				}
+
+				tokens = append(tokens, token(Number, num, start, c.pos))
+
+			} else if isAlpha(char) {
+				ident := "" // ident could be a variable name, or it could be a keyword
+				for c.remaining() > 0 && isAlpha(c.at()) {
+					ident += c.advance()
+				}
+
+				// check for reserved keyword
+				// a miss will be the types zero value, so 0
+				reserved := keywords[ident]
+
+				// TokenType is iota + 1 so TokenType will always be greater than 0
+				if reserved == 0 {
+					tokens = append(tokens, token(Identifier, ident, start, c.pos))
+				} else {
+					tokens = append(tokens, token(reserved, ident, start, c.pos))
+				}
+
+			} else if isSkipable(char) {
+				c.advance()
+			} else {
+				// Leave it to the parser to report so that one bad character doesn't hide every other error
+				c.advance()
+				tokens = append(tokens, token(Illegal, char, start, c.pos))
+			}
+		}
+	}
+	return append(tokens, token(EOF, "EOF", c.pos, c.pos))
+}
+
+// Lexes a string literal delimited by " or ', the token value is the string with escapes already applied
+func lexString(c *cursor) Token {
+	start := c.pos
+	quote := c.advance()
+	var value strings.Builder
+
+	for c.remaining() > 0 && c.at() != quote {
+		char := c.advance()
+		if char != backslash {
+			value.WriteString(char)
+			continue
+		}
+
+		if c.remaining() == 0 {
+			break
+		}
+
+		escapePos := c.pos
+		escape := c.advance()
+		switch escape {
+		case "n":
+			value.WriteString("\n")
+		case "t":
+			value.WriteString("\t")
+		case "r":
+			value.WriteString("\r")
+		case "0":
+			value.WriteString("\x00")
+		case backslash, doubleQuote, singleQuote:
+			value.WriteString(escape)
+		case "u":
+			r, ok := lexUnicodeEscape(c)
+			if !ok {
+				return illegalString(c, start, quote, fmt.Sprintf("Invalid unicode escape at %s, expected \\u{hex digits}", escapePos))
+			}
+			value.WriteRune(r)
+		default:
+			return illegalString(c, start, quote, fmt.Sprintf("Unknown escape sequence \\%s at %s", escape, escapePos))
+		}
+	}
+
+	if c.remaining() == 0 {
+		illegal := token(Illegal, quote+value.String(), start, c.pos)
+		illegal.Error = "Unterminated string literal"
+		return illegal
+	}
+	c.advance() // closing quote
+
+	return token(String, value.String(), start, c.pos)
+}
+
+// Lexes the {XXXX} part of a \u{XXXX} escape
+func lexUnicodeEscape(c *cursor) (rune, bool) {
+	if c.remaining() == 0 || c.at() != leftCurlyBracket {
+		return 0, false
+	}
+	c.advance()
+
+	digits := ""
+	for c.remaining() > 0 && c.at() != rightCurlyBracket && len(digits) <= 6 {
+		digits += c.advance()
+	}
+	if c.remaining() == 0 || c.at() != rightCurlyBracket {
+		return 0, false
+	}
+	c.advance()
+
+	code, err := strconv.ParseUint(digits, 16, 32)
+	if err != nil || !utf8.ValidRune(rune(code)) {
+		return 0, false
+	}
+	return rune(code), true
+}
+
+// Skips the rest of a broken string literal so that lexing can carry on after it
+func illegalString(c *cursor, start Position, quote string, message string) Token {
+	for c.remaining() > 0 && c.at() != quote {
+		if c.advance() == backslash && c.remaining() > 0 {
+			c.advance()
+		}
+	}
+	if c.remaining() > 0 {
+		c.advance()
+	}
+
+	illegal := token(Illegal, quote, start, c.pos)
+	illegal.Error = message
+	return illegal
+}
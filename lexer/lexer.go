@@ -1,10 +1,10 @@
 package lexer
 
 import (
-	"QuonkScript/utils"
 	"fmt"
-	"regexp"
-	"strings"
+	"io"
+	"strconv"
+	"unicode/utf8"
 )
 
 type TokenType int
@@ -14,6 +14,7 @@ const (
 	Null TokenType = iota + 1
 	Number
 	Identifier
+	String
 
 	// Keywords
 	Mut
@@ -25,6 +26,10 @@ const (
 	Elseif
 	Func
 	Return
+	While
+	For
+	Break
+	Continue
 
 	// Grouping and operations
 	Equals
@@ -47,274 +52,493 @@ const (
 	NotEqual
 	And
 	Or
+	Bang
+	Arrow
 
-	EOF // End of File
+	Illegal // Unrecognized character, reported by the parser
+	EOF     // End of File
 )
 
+var tokenNames = map[TokenType]string{
+	Null:               "null",
+	Number:             "number",
+	Identifier:         "identifier",
+	String:             "string",
+	Mut:                "mut",
+	Const:              "const",
+	True:               "true",
+	False:              "false",
+	If:                 "if",
+	Else:               "else",
+	Elseif:             "elseif",
+	Func:               "func",
+	Return:             "return",
+	While:              "while",
+	For:                "for",
+	Break:              "break",
+	Continue:           "continue",
+	Equals:             "=",
+	Semicolon:          ";",
+	OpenParen:          "(",
+	CloseParen:         ")",
+	BinaryOperator:     "operator",
+	OpenCurlyBracket:   "{",
+	CloseCurlyBracket:  "}",
+	Comma:              ",",
+	Colon:              ":",
+	OpenSquareBracket:  "[",
+	CloseSquareBracket: "]",
+	Dot:                ".",
+	Equality:           "==",
+	GreaterThan:        ">",
+	LessThan:           "<",
+	GreaterEqualTo:     ">=",
+	LessEqualTo:        "<=",
+	NotEqual:           "!=",
+	And:                "&&",
+	Or:                 "||",
+	Bang:               "!",
+	Arrow:              "=>",
+	Illegal:            "illegal character",
+	EOF:                "end of file",
+}
+
+func (t TokenType) String() string {
+	if name, ok := tokenNames[t]; ok {
+		return name
+	}
+	return fmt.Sprintf("TokenType(%d)", int(t))
+}
+
 const (
-	leftParen          = "("
-	rightParen         = ")"
-	addSym             = "+"
-	multSym            = "*"
-	divSym             = "/"
-	subSym             = "-"
-	eqSym              = "="
-	modSym             = "%"
-	semi               = ";"
-	leftCurlyBracket   = "{"
-	rightCurlyBracket  = "}"
-	comma              = ","
-	colon              = ":"
-	leftSquareBracket  = "["
-	rightSquareBracket = "]"
-	dot                = "."
-	greaterThan        = ">"
-	lessThan           = "<"
-	equality           = "=="
-	leq                = "<="
-	geq                = ">="
-	bang               = "!"
-	ampersand          = "&"
-	pipe               = "|"
+	leftParen          = '('
+	rightParen         = ')'
+	addSym             = '+'
+	multSym            = '*'
+	divSym             = '/'
+	subSym             = '-'
+	eqSym              = '='
+	modSym             = '%'
+	semi               = ';'
+	leftCurlyBracket   = '{'
+	rightCurlyBracket  = '}'
+	comma              = ','
+	colon              = ':'
+	leftSquareBracket  = '['
+	rightSquareBracket = ']'
+	dot                = '.'
+	greaterThan        = '>'
+	lessThan           = '<'
+	bang               = '!'
+	ampersand          = '&'
+	pipe               = '|'
+	doubleQuote        = '"'
+	singleQuote        = '\''
+	backslash          = '\\'
 )
 
+// Position is a location in the source. Line and Column are 1-based and Column
+// counts characters, Offset is the 0-based byte offset into the source.
+type Position struct {
+	Offset int
+	Line   int
+	Column int
+}
+
+func (p Position) String() string {
+	return fmt.Sprintf("%d:%d", p.Line, p.Column)
+}
+
+// Positions are marshalled as line:column to keep PrintAST output readable
+func (p Position) MarshalText() ([]byte, error) {
+	return []byte(p.String()), nil
+}
+
 type Token struct {
 	Value string
 	Type  TokenType
+	Pos   Position // position of the first character of the token
+	End   Position // position immediately after the last character of the token
+	Error string   // explains what is wrong with an Illegal token, empty for an unrecognized character
 }
 
-func isAlpha(s string) bool {
-	return regexp.MustCompile(`^[a-zA-Z]+$`).MatchString(s)
+func isAlpha(char byte) bool {
+	return 'a' <= char && char <= 'z' || 'A' <= char && char <= 'Z'
 }
 
-func isNumeric(s string) bool {
-	return regexp.MustCompile(`^[0-9]+$`).MatchString(s)
+func isNumeric(char byte) bool {
+	return '0' <= char && char <= '9'
 }
 
-func isSkipable(s string) bool {
-	return s == " " || s == "\n" || s == "\t" || s == "\r"
+func isSkipable(char byte) bool {
+	return char == ' ' || char == '\n' || char == '\t' || char == '\r'
 }
 
-func token(Type TokenType, Value string) Token {
-	return Token{Type: Type, Value: Value}
+func token(Type TokenType, Value string, pos Position, end Position) Token {
+	return Token{Type: Type, Value: Value, Pos: pos, End: end}
 }
 
-func getKeywordMap() map[string]TokenType {
-	return map[string]TokenType{
-		"mut":    Mut,
-		"const":  Const,
-		"null":   Null,
-		"true":   True,
-		"false":  False,
-		"if":     If,
-		"else":   Else,
-		"elseif": Elseif,
-		"func":   Func,
-		"return": Return,
-	}
+var keywords = map[string]TokenType{
+	"mut":      Mut,
+	"const":    Const,
+	"null":     Null,
+	"true":     True,
+	"false":    False,
+	"if":       If,
+	"else":     Else,
+	"elseif":   Elseif,
+	"func":     Func,
+	"return":   Return,
+	"while":    While,
+	"for":      For,
+	"break":    Break,
+	"continue": Continue,
+}
+
+// Lexer turns source into tokens one at a time, see Next
+type Lexer struct {
+	c    cursor
+	text []byte // the value of the token being lexed
 }
 
+func New(source string) *Lexer {
+	return &Lexer{c: newCursor([]byte(source), nil)}
+}
+
+// Lexes the source read from r as it is needed. Reading stops at the first error, see Err
+func NewReader(r io.Reader) *Lexer {
+	return &Lexer{c: newCursor(make([]byte, 0, chunkSize), r)}
+}
+
+// The error that stopped reading the source, nil if all of it was read
+func (l *Lexer) Err() error {
+	return l.c.err
+}
+
+// Lexes the whole source, the last token is always EOF
 func Tokenize(source string) []Token {
-	tokens := make([]Token, 0)
-	keywords := getKeywordMap()
-
-	src := strings.Split(source, "")
-	remaining := len(src)
-
-	// Build each token
-	for len(src) > 0 {
-
-		// src[0] will always be defined because len(src) > 0
-		char := src[0]
-
-		switch char {
-		case leftParen:
-			tokens = append(tokens, token(OpenParen, char))
-			// Pop first char
-			src = utils.Pop(src)
-			remaining--
-
-		case rightParen:
-			tokens = append(tokens, token(CloseParen, char))
-			src = utils.Pop(src)
-			remaining--
-
-		case leftCurlyBracket:
-			tokens = append(tokens, token(OpenCurlyBracket, char))
-			src = utils.Pop(src)
-			remaining--
-
-		case rightCurlyBracket:
-			tokens = append(tokens, token(CloseCurlyBracket, char))
-			src = utils.Pop(src)
-			remaining--
-
-		case leftSquareBracket:
-			tokens = append(tokens, token(OpenSquareBracket, char))
-			src = utils.Pop(src)
-			remaining--
-		case rightSquareBracket:
-			tokens = append(tokens, token(CloseSquareBracket, char))
-			src = utils.Pop(src)
-			remaining--
-		case addSym:
-			fallthrough
-		case subSym:
-			fallthrough
-		case divSym:
-			fallthrough
-		case modSym:
-			fallthrough
-		case multSym:
-			tokens = append(tokens, token(BinaryOperator, char))
-			src = utils.Pop(src)
-			remaining--
-		case eqSym:
-			// check for equality symbol here
-			src = utils.Pop(src)
-			remaining--
-			if remaining > 0 { // If there are symbols left to parse
-				nextChar := src[0] // This is safe because remaining > 0
-				// another equals sign
-				if nextChar == eqSym {
-					tokens = append(tokens, token(Equality, "=="))
-					src = utils.Pop(src)
-					remaining--
-				} else {
-					tokens = append(tokens, token(Equals, char))
-				}
-			} else {
-				tokens = append(tokens, token(Equals, char))
-			}
-		case greaterThan: // >= or >
-			// need to check next char
-			src = utils.Pop(src)
-			remaining--
-			if remaining > 0 { // If there are symbols left to parse
-				nextChar := src[0] // This is safe because remaining > 0
-				// looking for equals sign
-				if nextChar == eqSym {
-					tokens = append(tokens, token(GreaterEqualTo, ">="))
-					src = utils.Pop(src)
-					remaining--
-				} else {
-					tokens = append(tokens, token(GreaterThan, char))
-				}
-			} else {
-				tokens = append(tokens, token(GreaterThan, char))
-			}
-		case lessThan: // <= or <
-			// need to check next char
-			src = utils.Pop(src)
-			remaining--
-			if remaining > 0 { // If there are symbols left to parse
-				nextChar := src[0] // This is safe because remaining > 0
-				// looking for equals sign
-				if nextChar == eqSym {
-					tokens = append(tokens, token(LessEqualTo, "<="))
-					src = utils.Pop(src)
-					remaining--
-				} else {
-					tokens = append(tokens, token(LessThan, char))
-				}
-			} else {
-				tokens = append(tokens, token(LessThan, char))
-			}
-		case bang:
-			// need to check next char
-			src = utils.Pop(src)
-			remaining--
-			if remaining > 0 { // If there are symbols left to parse
-				nextChar := src[0] // This is safe because remaining > 0
-				// looking for equals sign
-				if nextChar == eqSym {
-					tokens = append(tokens, token(NotEqual, "!="))
-					src = utils.Pop(src)
-					remaining--
-				}
-			}
-		case ampersand:
-			src = utils.Pop(src)
-			remaining--
-			if remaining > 0 {
-				nextChar := src[0]
-				if nextChar == ampersand {
-					tokens = append(tokens, token(And, "&&"))
-					src = utils.Pop(src)
-					remaining--
-				} else {
-					panic(fmt.Sprintf("Honk! Unrecognized character %s", char))
-				}
-			}
-		case pipe:
-			src = utils.Pop(src)
-			remaining--
-			if remaining > 0 {
-				nextChar := src[0]
-				if nextChar == pipe {
-					tokens = append(tokens, token(And, "||"))
-					src = utils.Pop(src)
-					remaining--
-				} else {
-					panic(fmt.Sprintf("Honk! Unrecognized character %s", char))
-				}
+	l := New(source)
+	tokens := make([]Token, 0, len(source)/4) // roughly one token every few characters
+
+	for {
+		token := l.Next()
+		tokens = append(tokens, token)
+		if token.Type == EOF {
+			return tokens
+		}
+	}
+}
+
+// Returns the next token, or an EOF token once the source runs out
+func (l *Lexer) Next() Token {
+	c := &l.c
+
+	for c.remaining() && isSkipable(c.at()) {
+		c.advance()
+	}
+	if !c.remaining() {
+		return token(EOF, "EOF", c.pos, c.pos)
+	}
+
+	// every token starts where the cursor currently is
+	start := c.pos
+	char := c.at()
+
+	switch char {
+	case leftParen:
+		c.advance()
+		return token(OpenParen, "(", start, c.pos)
+	case rightParen:
+		c.advance()
+		return token(CloseParen, ")", start, c.pos)
+	case leftCurlyBracket:
+		c.advance()
+		return token(OpenCurlyBracket, "{", start, c.pos)
+	case rightCurlyBracket:
+		c.advance()
+		return token(CloseCurlyBracket, "}", start, c.pos)
+	case leftSquareBracket:
+		c.advance()
+		return token(OpenSquareBracket, "[", start, c.pos)
+	case rightSquareBracket:
+		c.advance()
+		return token(CloseSquareBracket, "]", start, c.pos)
+	case addSym, subSym, divSym, modSym, multSym:
+		c.advance()
+		return token(BinaryOperator, string(char), start, c.pos)
+	case eqSym:
+		c.advance()
+		if c.is(eqSym) { // another equals sign
+			c.advance()
+			return token(Equality, "==", start, c.pos)
+		} else if c.is(greaterThan) { // arrow function
+			c.advance()
+			return token(Arrow, "=>", start, c.pos)
+		}
+		return token(Equals, "=", start, c.pos)
+	case greaterThan: // >= or >
+		return l.lexOperator(GreaterThan, ">", eqSym, GreaterEqualTo, ">=")
+	case lessThan: // <= or <
+		return l.lexOperator(LessThan, "<", eqSym, LessEqualTo, "<=")
+	case bang: // != or !
+		return l.lexOperator(Bang, "!", eqSym, NotEqual, "!=")
+	case ampersand: // a single & is not an operator
+		return l.lexOperator(Illegal, "&", ampersand, And, "&&")
+	case pipe:
+		return l.lexOperator(Illegal, "|", pipe, Or, "||")
+	case semi:
+		c.advance()
+		return token(Semicolon, ";", start, c.pos)
+	case colon:
+		c.advance()
+		return token(Colon, ":", start, c.pos)
+	case comma:
+		c.advance()
+		return token(Comma, ",", start, c.pos)
+	case dot:
+		c.advance()
+		return token(Dot, ".", start, c.pos)
+	case doubleQuote, singleQuote:
+		return l.lexString()
+	}
+
+	// Handle multichar token
+	if isNumeric(char) {
+		return l.lexNumber()
+	}
+
+	if isAlpha(char) {
+		ident := l.lexWhile(isAlpha) // ident could be a variable name, or it could be a keyword
+
+		// check for reserved keyword
+		if reserved, ok := keywords[ident]; ok {
+			return token(reserved, ident, start, c.pos)
+		}
+		return token(Identifier, ident, start, c.pos)
+	}
+
+	// Leave it to the parser to report so that one bad character doesn't hide every other error
+	return token(Illegal, string(c.advance()), start, c.pos)
+}
+
+// Lexes a one character operator that becomes a two character operator when it is followed by next
+func (l *Lexer) lexOperator(single TokenType, value string, next byte, double TokenType, doubleValue string) Token {
+	start := l.c.pos
+	l.c.advance()
+	if l.c.is(next) {
+		l.c.advance()
+		return token(double, doubleValue, start, l.c.pos)
+	}
+	return token(single, value, start, l.c.pos)
+}
+
+// Consumes characters while they match and returns them
+func (l *Lexer) lexWhile(matches func(byte) bool) string {
+	l.text = l.text[:0]
+	for l.c.remaining() && matches(l.c.at()) {
+		l.text = append(l.text, l.c.advance()...)
+	}
+	return string(l.text)
+}
+
+// Lexes a number literal such as 42, 3.14, 1e-9, 0xFF or 0b1010. _ can separate digits, as in 1_000_000.
+// Letters, digits and dots that run on from a number are part of it, so 1.2.3 and 12ab are one malformed number
+func (l *Lexer) lexNumber() Token {
+	c := &l.c
+	start := c.pos
+	l.text = append(l.text[:0], c.advance()...)
+
+	for c.remaining() {
+		char := c.at()
+		last := l.text[len(l.text)-1]
+		// The sign of an exponent, hex digits include e so 0x1e+1 is an addition
+		isSign := (char == '+' || char == '-') && (last == 'e' || last == 'E') && !isHexOrBinary(l.text)
+
+		if !isNumeric(char) && !isAlpha(char) && char != '_' && char != dot && !isSign {
+			break
+		}
+		l.text = append(l.text, c.advance()...)
+	}
+
+	num := string(l.text)
+	if message := checkNumber(num); message != "" {
+		illegal := token(Illegal, num, start, c.pos)
+		illegal.Error = message
+		return illegal
+	}
+	return token(Number, num, start, c.pos)
+}
+
+func isHexOrBinary(num []byte) bool {
+	return len(num) >= 2 && num[0] == '0' && (num[1] == 'x' || num[1] == 'X' || num[1] == 'b' || num[1] == 'B')
+}
+
+func isHexDigit(char byte) bool {
+	return isNumeric(char) || 'a' <= char && char <= 'f' || 'A' <= char && char <= 'F'
+}
+
+func isBinaryDigit(char byte) bool {
+	return char == '0' || char == '1'
+}
+
+// Explains what is wrong with a number literal, or returns "" if nothing is
+func checkNumber(num string) string {
+	if isHexOrBinary([]byte(num)) {
+		isDigit := isHexDigit
+		if num[1] == 'b' || num[1] == 'B' {
+			isDigit = isBinaryDigit
+		}
+		if len(num) == 2 {
+			return fmt.Sprintf("Malformed number %s, expected digits after %s", num, num)
+		}
+		if rest := skipDigits(num[2:], isDigit); rest != "" {
+			return fmt.Sprintf("Malformed number %s", num)
+		}
+		return checkSeparators(num, num[2:], isDigit)
+	}
+
+	// digits [. digits] [e [+-] digits]
+	rest := skipDigits(num, isNumeric)
+	if rest != "" && rest[0] == dot {
+		fraction := skipDigits(rest[1:], isNumeric)
+		if len(fraction) == len(rest)-1 {
+			return fmt.Sprintf("Malformed number %s, expected digits after the decimal point", num)
+		}
+		rest = fraction
+	}
+	if rest != "" && (rest[0] == 'e' || rest[0] == 'E') {
+		exponent := rest[1:]
+		if exponent != "" && (exponent[0] == '+' || exponent[0] == '-') {
+			exponent = exponent[1:]
+		}
+		rest = skipDigits(exponent, isNumeric)
+		if len(rest) == len(exponent) {
+			return fmt.Sprintf("Malformed number %s, expected digits in the exponent", num)
+		}
+	}
+	if rest != "" {
+		if rest[0] == dot {
+			return fmt.Sprintf("Malformed number %s, a number can only have one decimal point", num)
+		}
+		return fmt.Sprintf("Malformed number %s", num)
+	}
+	// e is not a digit here, so 1e_5 and 1_e5 are malformed
+	return checkSeparators(num, num, isNumeric)
+}
+
+// Returns what is left of num after the digits and separators it starts with
+func skipDigits(num string, isDigit func(byte) bool) string {
+	for num != "" && (isDigit(num[0]) || num[0] == '_') {
+		num = num[1:]
+	}
+	return num
+}
+
+// _ can only go between two digits of num, which are the digits after the prefix of hex and binary numbers.
+// Not next to a prefix, decimal point, exponent or sign
+func checkSeparators(num string, digits string, isDigit func(byte) bool) string {
+	for i := 0; i < len(digits); i++ {
+		if digits[i] != '_' {
+			continue
+		}
+		if i == 0 || i == len(digits)-1 || !isDigit(digits[i-1]) || !isDigit(digits[i+1]) {
+			return fmt.Sprintf("Malformed number %s, _ can only separate digits", num)
+		}
+	}
+	return ""
+}
+
+// Lexes a string literal delimited by " or ', the token value is the string with escapes already applied
+func (l *Lexer) lexString() Token {
+	c := &l.c
+	start := c.pos
+	quote := c.advance()[0]
+	l.text = l.text[:0]
+
+	for c.remaining() && c.at() != quote {
+		char := c.advance()
+		if char[0] != backslash {
+			l.text = append(l.text, char...)
+			continue
+		}
+
+		if !c.remaining() {
+			break
+		}
+
+		escapePos := c.pos
+		escape := c.advance()
+		switch escape[0] {
+		case 'n':
+			l.text = append(l.text, '\n')
+		case 't':
+			l.text = append(l.text, '\t')
+		case 'r':
+			l.text = append(l.text, '\r')
+		case '0':
+			l.text = append(l.text, 0)
+		case backslash, doubleQuote, singleQuote:
+			l.text = append(l.text, escape[0])
+		case 'u':
+			r, ok := l.lexUnicodeEscape()
+			if !ok {
+				return l.illegalString(start, quote, fmt.Sprintf("Invalid unicode escape at %s, expected \\u{hex digits}", escapePos))
 			}
-		case semi:
-			tokens = append(tokens, token(Semicolon, char))
-			src = utils.Pop(src)
-			remaining--
-		case colon:
-			tokens = append(tokens, token(Colon, char))
-			src = utils.Pop(src)
-			remaining--
-		case comma:
-			tokens = append(tokens, token(Comma, char))
-			src = utils.Pop(src)
-			remaining--
-		case dot:
-			tokens = append(tokens, token(Dot, char))
-			src = utils.Pop(src)
-			remaining--
+			l.text = utf8.AppendRune(l.text, r)
 		default:
-			// Handle multichar token
-			if isNumeric(char) {
-				num := ""
-				// while there are characters left to Parse and the characters are numeric
-				// We don't use char here because we want to process entire multichar number within this switch case
-				for len(src) > 0 && isNumeric(src[0]) {
-					num += src[0]
-					src = utils.Pop(src)
-					remaining--
-				}
-
-				tokens = append(tokens, token(Number, num))
-
-			} else if isAlpha(char) {
-				ident := "" // ident could be a variable name, or it could be a keyword
-				for len(src) > 0 && isAlpha(src[0]) {
-					ident += src[0]
-					src = utils.Pop(src)
-					remaining--
-				}
-
-				// check for reserved keyword
-				// a miss will be the types zero value, so 0
-				reserved := keywords[ident]
-
-				// TokenType is iota + 1 so TokenType will always be greater than 0
-				if reserved == 0 {
-					tokens = append(tokens, token(Identifier, ident))
-				} else {
-					tokens = append(tokens, token(reserved, ident))
-				}
-
-			} else if isSkipable(char) {
-				src = utils.Pop(src)
-				remaining--
-			} else {
-				panic(fmt.Sprintf("Unrecognized character %s", char))
-			}
+			return l.illegalString(start, quote, fmt.Sprintf("Unknown escape sequence \\%s at %s", escape, escapePos))
 		}
 	}
-	return append(tokens, token(EOF, "EOF"))
+
+	if !c.remaining() {
+		illegal := token(Illegal, string(quote)+string(l.text), start, c.pos)
+		illegal.Error = "Unterminated string literal"
+		return illegal
+	}
+	c.advance() // closing quote
+
+	return token(String, string(l.text), start, c.pos)
+}
+
+// Lexes the {XXXX} part of a \u{XXXX} escape
+func (l *Lexer) lexUnicodeEscape() (rune, bool) {
+	c := &l.c
+	if !c.is(leftCurlyBracket) {
+		return 0, false
+	}
+	c.advance()
+
+	// Stops at anything that is not a digit, so a missing } never takes the closing quote with it. Six digits are
+	// enough for any code point, a seventh is an error
+	var digits []byte
+	for c.remaining() && isHexDigit(c.at()) && len(digits) < 6 {
+		digits = append(digits, c.advance()...)
+	}
+	if !c.is(rightCurlyBracket) {
+		return 0, false
+	}
+	c.advance()
+
+	code, err := strconv.ParseUint(string(digits), 16, 32)
+	if err != nil || !utf8.ValidRune(rune(code)) {
+		return 0, false
+	}
+	return rune(code), true
+}
+
+// Skips the rest of a broken string literal so that lexing can carry on after it
+func (l *Lexer) illegalString(start Position, quote byte, message string) Token {
+	c := &l.c
+	for c.remaining() && c.at() != quote {
+		if c.advance()[0] == backslash && c.remaining() {
+			c.advance()
+		}
+	}
+	if c.remaining() {
+		c.advance()
+	}
+
+	illegal := token(Illegal, string(quote), start, c.pos)
+	illegal.Error = message
+	return illegal
 }
@@ -0,0 +1,205 @@
+package lexer
+
+import (
+	"QuonkScript/lexer/internal/baseline"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+// Errors of the Illegal tokens in src, in order
+func illegalErrors(src string) []string {
+	errors := []string{}
+	for _, token := range Tokenize(src) {
+		if token.Type == Illegal {
+			errors = append(errors, token.Error)
+		}
+	}
+	return errors
+}
+
+func TestUnicodeEscapeMissingBraceKeepsQuote(t *testing.T) {
+	src := "print(\"\\u{41\");\nprint(\"ok\")\nprint(\"\\q\"); print(2)"
+	want := []string{
+		"Invalid unicode escape at 1:9, expected \\u{hex digits}",
+		"Unknown escape sequence \\q at 3:9",
+	}
+
+	got := illegalErrors(src)
+	if len(got) != len(want) {
+		t.Fatalf("got errors %q, want %q", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("error %d is %q, want %q", i, got[i], want[i])
+		}
+	}
+
+	// Everything after the broken string is lexed as usual
+	tokens := Tokenize(src)
+	if tokens[4].Type != Semicolon || tokens[7].Type != String || tokens[7].Value != "ok" {
+		t.Errorf("tokens after the broken string are %v", tokens[4:8])
+	}
+}
+
+func TestUnicodeEscapeLength(t *testing.T) {
+	for src, want := range map[string]string{
+		"\"\\u{41}\"":      "A",
+		"\"\\u{000041}\"":  "A",
+		"\"\\u{10FFFF}\"":  "\U0010FFFF",
+		"\"\\u{0000041}\"": "",
+		"\"\\u{110000}\"":  "",
+		"\"\\u{}\"":        "",
+	} {
+		token := Tokenize(src)[0]
+		if want == "" {
+			if token.Type != Illegal || token.Error != "Invalid unicode escape at 1:3, expected \\u{hex digits}" {
+				t.Errorf("%s lexed as %+v, want an invalid unicode escape", src, token)
+			}
+		} else if token.Type != String || token.Value != want {
+			t.Errorf("%s lexed as %+v, want the string %q", src, token, want)
+		}
+	}
+}
+
+func TestNumbers(t *testing.T) {
+	for _, src := range []string{"42", "3.14", "1e-9", "2.5E+3", "0xFF", "0Xff", "0b1010", "1_000_000", "0xdead_beef", "0b1_0", "1_0.2_5e1_0"} {
+		tokens := Tokenize(src)
+		if tokens[0].Type != Number || tokens[0].Value != src || tokens[1].Type != EOF {
+			t.Errorf("%s lexed as %v", src, tokens)
+		}
+	}
+}
+
+func TestMalformedNumbers(t *testing.T) {
+	for src, want := range map[string]string{
+		"1.2.3":  "Malformed number 1.2.3, a number can only have one decimal point",
+		"1.":     "Malformed number 1., expected digits after the decimal point",
+		"1e":     "Malformed number 1e, expected digits in the exponent",
+		"1e+":    "Malformed number 1e+, expected digits in the exponent",
+		"0x":     "Malformed number 0x, expected digits after 0x",
+		"0b102":  "Malformed number 0b102",
+		"12ab":   "Malformed number 12ab",
+		"1__0":   "Malformed number 1__0, _ can only separate digits",
+		"1_":     "Malformed number 1_, _ can only separate digits",
+		"0x_ff":  "Malformed number 0x_ff, _ can only separate digits",
+		"1e_5":   "Malformed number 1e_5, _ can only separate digits",
+		"1_e5":   "Malformed number 1_e5, _ can only separate digits",
+		"1E_5":   "Malformed number 1E_5, _ can only separate digits",
+		"1_.5":   "Malformed number 1_.5, _ can only separate digits",
+		"1._5":   "Malformed number 1._5, _ can only separate digits",
+		"1e+_5":  "Malformed number 1e+_5, _ can only separate digits",
+		"1.5_e2": "Malformed number 1.5_e2, _ can only separate digits",
+	} {
+		tokens := Tokenize(src)
+		if tokens[0].Type != Illegal || tokens[0].Value != src || tokens[0].Error != want {
+			t.Errorf("%s lexed as %+v, want the error %q", src, tokens[0], want)
+		}
+	}
+}
+
+// Sources that cover every kind of token, broken input and characters that are more than one byte long
+var equivalenceSources = []string{
+	"",
+	"mut x = 1;\nconst y = x + 2 * (3 - 4) / 5 % 6;",
+	"if (a == b && c != d || !e) { print(a) } elseif (a <= b) { } else { a >= b }",
+	"fn add(a, b) { return a + b }\nconst f = (x) => x * 2;\nfunc (x) { x }",
+	"for (mut i = 0; i < 10; i = i + 1) { if (i > 5) { break } continue }\nwhile (true) { }",
+	"{ a: 1, b: [1, 2.5, 0xFF, 0b101, 1e-9, 1_000], c: null, d: false }.a[0]",
+	"'single' \"double\" \"esc\\n\\t\\\"\\u{1F600}\" \"héllo wörld ✓ 😀\"",
+	"\"unterminated",
+	"\"\\u{41\" \"\\q\" 1.2.3 12ab @ # é ✓",
+	"a\r\nb\tc  \n\n  d",
+	"\xff\xfe ok \"\xff\"",
+}
+
+// Benchmark scripts repeated until they are larger than a chunk read from an io.Reader
+func largeSource(tb testing.TB, size int) string {
+	files, err := filepath.Glob("../benchmarks/*.qs")
+	if err != nil || len(files) == 0 {
+		tb.Fatalf("no benchmark scripts: %v", err)
+	}
+
+	var src strings.Builder
+	for src.Len() < size {
+		for _, file := range files {
+			bytes, err := os.ReadFile(file)
+			if err != nil {
+				tb.Fatal(err)
+			}
+			src.Write(bytes)
+			// Multibyte characters, so that some of them are split between chunks
+			src.WriteString("\n\"ünïcödé ✓ 😀\"\n")
+		}
+	}
+	return src.String()
+}
+
+func pull(l *Lexer) []Token {
+	tokens := []Token{}
+	for {
+		token := l.Next()
+		tokens = append(tokens, token)
+		if token.Type == EOF {
+			return tokens
+		}
+	}
+}
+
+func sameTokens(t *testing.T, name string, got []Token, want []Token) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Errorf("%s: got %d tokens, want %d", name, len(got), len(want))
+		return
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("%s: token %d is %+v, want %+v", name, i, got[i], want[i])
+			return
+		}
+	}
+}
+
+// Tokenize, Next and lexing from an io.Reader must all produce the same tokens
+func TestTokenizeMatchesNextAndReader(t *testing.T) {
+	sources := append([]string{largeSource(t, 3*chunkSize)}, equivalenceSources...)
+	for i, src := range sources {
+		want := Tokenize(src)
+		sameTokens(t, fmt.Sprintf("source %d with Next", i), pull(New(src)), want)
+		sameTokens(t, fmt.Sprintf("source %d from a reader", i), pull(NewReader(strings.NewReader(src))), want)
+		sameTokens(t, fmt.Sprintf("source %d one byte at a time", i), pull(NewReader(iotest.OneByteReader(strings.NewReader(src)))), want)
+	}
+}
+
+func BenchmarkTokenize(b *testing.B) {
+	src := largeSource(b, 256*1024)
+	b.SetBytes(int64(len(src)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		Tokenize(src)
+	}
+}
+
+// The lexer before the rewrite, on the same source as BenchmarkTokenize
+func BenchmarkBaselineTokenize(b *testing.B) {
+	src := largeSource(b, 256*1024)
+	b.SetBytes(int64(len(src)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		baseline.Tokenize(src)
+	}
+}
+
+func BenchmarkNextReader(b *testing.B) {
+	src := largeSource(b, 256*1024)
+	b.SetBytes(int64(len(src)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		l := NewReader(strings.NewReader(src))
+		for l.Next().Type != EOF {
+		}
+	}
+}
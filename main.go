@@ -1,70 +1,124 @@
 package main
 
 import (
-	"QuonkScript/parser"
+	"QuonkScript/quonk"
 	"QuonkScript/runtime"
 	"bufio"
+	"errors"
+	"flag"
 	"fmt"
+	"io/fs"
 	"os"
 	"strings"
+	"time"
+)
+
+// Exit codes of a failed run, from sysexits.h
+const (
+	exitDataErr  = 65 // the script has syntax errors or mistakes with variables
+	exitNoInput  = 66 // the script cannot be read
+	exitSoftware = 70 // the script raised an error while running
+)
+
+var (
+	useVM       = flag.Bool("vm", false, "compile to bytecode and run it on the vm instead of the tree-walking interpreter")
+	showTime    = flag.Bool("time", false, "print how long the script took to run to stderr")
+	disassemble = flag.Bool("disassemble", false, "print the bytecode of the script instead of running it")
 )
 
 func main() {
-	args := os.Args
+	flag.Parse()
+	args := flag.Args()
 
-	if len(args) == 1 {
+	if len(args) == 0 {
 		// If no filename was passed as a command line argument, run the repl
 		repl()
+	} else if *disassemble {
+		printBytecode(args[0])
 	} else {
-		// Script name should be second arg
-		run(args[1])
+		// Script name should be the first arg after the flags
+		run(args[0])
 	}
 
 }
 
 func repl() {
-	p := parser.Parser{}
-	scope := &runtime.Scope{Parent: nil, Variables: make(map[string]runtime.Variable)}
+	interpreter := quonk.New(quonk.Options{VM: *useVM})
 	fmt.Println("REPL v0.1")
 	in := bufio.NewReader(os.Stdin)
 
-	runtime.SetupScope(scope)
 	// https://www.youtube.com/watch?v=uwKnc4w15nk&list=PL_2VhOvlMk4UHGqYCLWc6GO8FaPl8fQTh&index=5 if you want to have null be an identifier which I do not right now
-	// scope.DeclareVariable("null", runtime.MakeNull())
+	// interpreter.SetGlobal("null", runtime.MakeNull())
 
 	for {
 		fmt.Print("> ")
 		input, err := in.ReadString('\n')
 
 		if err != nil {
-			fmt.Println("Invalid Input")
+			fmt.Fprintln(os.Stderr, "Invalid Input")
 			return
 		}
 		if strings.Contains(input, "exit") {
 			os.Exit(0)
 		}
-		prog := p.ProduceAST(input)
 
-		result := runtime.Evaluate(prog, scope)
+		result, err := interpreter.RunString(input)
+		if err != nil {
+			printError(err)
+			continue
+		}
 		fmt.Println(result)
 	}
 }
 
 func run(filename string) {
+	interpreter := quonk.New(quonk.Options{VM: *useVM})
+
+	start := time.Now()
+	result, err := interpreter.RunFile(filename)
+	if *showTime {
+		fmt.Fprintf(os.Stderr, "%s took %s\n", filename, time.Since(start))
+	}
+	if err != nil {
+		printError(err)
+		os.Exit(exitCode(err))
+	}
+
+	fmt.Println(result)
+}
+
+func printBytecode(filename string) {
 	bytes, err := os.ReadFile(filename)
 	if err != nil {
-		fmt.Printf("Honk! Cannot read file %s\n", filename)
-		return
+		fmt.Fprintf(os.Stderr, "Honk! Cannot read file %s: %s\n", filename, err)
+		os.Exit(exitNoInput)
 	}
 
-	src := string(bytes)
-	p := parser.Parser{}
-	scope := &runtime.Scope{Parent: nil, Variables: make(map[string]runtime.Variable)}
-	runtime.SetupScope(scope)
+	function, err := quonk.New(quonk.Options{}).Compile(string(bytes))
+	if err != nil {
+		printError(err)
+		os.Exit(exitCode(err))
+	}
+	fmt.Print(function.Disassemble())
+}
 
-	prog := p.ProduceAST(src)
-	// parser.PrintAST(prog)
-	result := runtime.Evaluate(prog, scope)
+// Prints parse errors one per line and runtime errors with their traceback to stderr
+func printError(err error) {
+	var runtimeErr *runtime.RuntimeError
+	if errors.As(err, &runtimeErr) {
+		fmt.Fprintln(os.Stderr, runtimeErr.Traceback())
+	} else {
+		fmt.Fprintln(os.Stderr, err)
+	}
+}
 
-	fmt.Println(result)
+func exitCode(err error) int {
+	var runtimeErr *runtime.RuntimeError
+	var pathErr *fs.PathError
+	if errors.As(err, &runtimeErr) {
+		return exitSoftware
+	} else if errors.As(err, &pathErr) {
+		return exitNoInput
+	}
+	return exitDataErr
 }
@@ -0,0 +1,90 @@
+package orderedmap
+
+// OrderedMap is a map with string keys that remembers the order keys were first inserted in.
+// Lookups, insertions and deletions are all O(1)
+type OrderedMap[V any] struct {
+	m     map[string]*entry[V]
+	first *entry[V]
+	last  *entry[V]
+}
+
+// entries form a doubly linked list in insertion order so that deleting keeps the order of the rest
+type entry[V any] struct {
+	key   string
+	value V
+	prev  *entry[V]
+	next  *entry[V]
+}
+
+func (o *OrderedMap[V]) Get(key string) (V, bool) {
+	e, ok := o.m[key]
+	if !ok {
+		var zero V
+		return zero, false
+	}
+	return e.value, true
+}
+
+// Updating an existing key keeps its original position
+func (o *OrderedMap[V]) Set(key string, value V) {
+	if e, ok := o.m[key]; ok {
+		e.value = value
+		return
+	}
+
+	e := &entry[V]{key: key, value: value, prev: o.last}
+	if o.last == nil {
+		o.first = e
+	} else {
+		o.last.next = e
+	}
+	o.last = e
+	o.m[key] = e
+}
+
+// Returns whether the key was present
+func (o *OrderedMap[V]) Delete(key string) bool {
+	e, ok := o.m[key]
+	if !ok {
+		return false
+	}
+
+	if e.prev == nil {
+		o.first = e.next
+	} else {
+		e.prev.next = e.next
+	}
+	if e.next == nil {
+		o.last = e.prev
+	} else {
+		e.next.prev = e.prev
+	}
+	delete(o.m, key)
+
+	return true
+}
+
+func (o *OrderedMap[V]) Has(key string) bool {
+	_, ok := o.m[key]
+	return ok
+}
+
+func (o *OrderedMap[V]) Len() int {
+	return len(o.m)
+}
+
+// Keys in insertion order
+func (o *OrderedMap[V]) Keys() []string {
+	keys := make([]string, 0, len(o.m))
+	for e := o.first; e != nil; e = e.next {
+		keys = append(keys, e.key)
+	}
+	return keys
+}
+
+func NewOrderedMap[V any]() *OrderedMap[V] {
+	o := &OrderedMap[V]{}
+	o.m = make(map[string]*entry[V])
+
+	return o
+}
@@ -0,0 +1,87 @@
+package orderedmap
+
+import "testing"
+
+// Checks the keys of o, in order, and that each one maps to its value in want
+func expectEntries(t *testing.T, o *OrderedMap[int], keys []string, want map[string]int) {
+	t.Helper()
+	got := o.Keys()
+	if len(got) != len(keys) || o.Len() != len(keys) {
+		t.Fatalf("got keys %q and Len %d, want %q", got, o.Len(), keys)
+	}
+	for i, key := range keys {
+		if got[i] != key {
+			t.Errorf("key %d is %q, want %q", i, got[i], key)
+		}
+		if value, ok := o.Get(key); !ok || value != want[key] {
+			t.Errorf("%q is %d, %t, want %d", key, value, ok, want[key])
+		}
+	}
+}
+
+func TestInsertionOrder(t *testing.T) {
+	o := NewOrderedMap[int]()
+	o.Set("c", 1)
+	o.Set("a", 2)
+	o.Set("b", 3)
+	expectEntries(t, o, []string{"c", "a", "b"}, map[string]int{"c": 1, "a": 2, "b": 3})
+
+	if _, ok := o.Get("d"); ok || o.Has("d") {
+		t.Error("found a key that was never set")
+	}
+}
+
+func TestOverwriteKeepsPosition(t *testing.T) {
+	o := NewOrderedMap[int]()
+	o.Set("a", 1)
+	o.Set("b", 2)
+	o.Set("a", 3)
+	expectEntries(t, o, []string{"a", "b"}, map[string]int{"a": 3, "b": 2})
+}
+
+func TestDeleteThenReinsertMovesToTheEnd(t *testing.T) {
+	o := NewOrderedMap[int]()
+	o.Set("a", 1)
+	o.Set("b", 2)
+	o.Set("c", 3)
+	if !o.Delete("a") {
+		t.Error("Delete of a key that was set returned false")
+	}
+	if o.Delete("a") {
+		t.Error("Delete of a deleted key returned true")
+	}
+	o.Set("a", 4)
+	expectEntries(t, o, []string{"b", "c", "a"}, map[string]int{"a": 4, "b": 2, "c": 3})
+}
+
+func TestIterateAfterDelete(t *testing.T) {
+	for _, test := range []struct {
+		deleted string
+		keys    []string
+	}{
+		{"a", []string{"b", "c", "d"}},
+		{"b", []string{"a", "c", "d"}},
+		{"d", []string{"a", "b", "c"}},
+	} {
+		o := NewOrderedMap[int]()
+		values := map[string]int{"a": 1, "b": 2, "c": 3, "d": 4}
+		for _, key := range []string{"a", "b", "c", "d"} {
+			o.Set(key, values[key])
+		}
+		o.Delete(test.deleted)
+		expectEntries(t, o, test.keys, values)
+
+		// The links around the deleted entry still work for entries set afterwards
+		o.Set("e", 5)
+		values["e"] = 5
+		expectEntries(t, o, append(test.keys, "e"), values)
+	}
+
+	// Deleting everything leaves an empty map that can be filled again
+	o := NewOrderedMap[int]()
+	o.Set("a", 1)
+	o.Delete("a")
+	expectEntries(t, o, []string{}, nil)
+	o.Set("b", 2)
+	expectEntries(t, o, []string{"b"}, map[string]int{"b": 2})
+}
@@ -1,6 +1,7 @@
 package parser
 
 import (
+	"QuonkScript/lexer"
 	"encoding/json"
 	"fmt"
 	"strconv"
@@ -15,14 +16,22 @@ const (
 	VarDeclarationNode
 	FunctionDeclarationNode
 	BranchNode
+	ReturnNode
+	WhileNode
+	ForNode
+	BreakNode
+	ContinueNode
 
 	// Literals
 	NumericLiteralNode
+	IntegerLiteralNode
 	NullLiteralNode
 	IdentifierNode
 	PropertyLiteralNode
 	ObjectLiteralNode
 	BooleanLiteralNode
+	StringLiteralNode
+	ArrayLiteralNode
 
 	// Expressions
 	BinaryExprNode
@@ -30,12 +39,25 @@ const (
 	MemberExprNode
 	InternalFunctionCallExprNode
 	ComparisonExprNode
+	UnaryExprNode
+	LogicalExprNode
+	FunctionExprNode
 )
 
+// Where the variable an identifier or declaration refers to lives, filled in by the resolver package.
+// The zero value looks the variable up by name, which is how globals are found and how ASTs that were not resolved run
+type Binding struct {
+	Local bool `json:"local"`
+	Depth int  `json:"depth"` // scopes to go up from the scope the node is evaluated in
+	Slot  int  `json:"slot"`  // index of the variable in that scope
+}
+
 // Node Interfaces
 type (
 	Node interface {
 		GetKind() NodeType
+		GetPos() lexer.Position // start of the node in the source
+		GetEnd() lexer.Position // position immediately after the node in the source
 	}
 	// Statements will not return a value
 	Stmt interface {
@@ -56,66 +78,107 @@ type (
 	}
 
 	BinaryExpr struct {
-		ExprStmt `json:"kind"` // Type should always be BinaryExprNode
-		Left     Expr          `json:"left"`
-		Right    Expr          `json:"right"`
-		Operator string        `json:"operator"`
+		ExprStmt `json:"kind"`  // Type should always be BinaryExprNode
+		Left     Expr           `json:"left"`
+		Right    Expr           `json:"right"`
+		Operator string         `json:"operator"`
+		Pos      lexer.Position `json:"pos"`
+		End      lexer.Position `json:"end"`
 	}
 
 	Ident struct {
-		ExprStmt `json:"kind"` // Type should always be IndentifierNode
-		Symbol   string        `json:"symbol"`
+		ExprStmt `json:"kind"`  // Type should always be IndentifierNode
+		Symbol   string         `json:"symbol"`
+		Binding  Binding        `json:"binding"`
+		Pos      lexer.Position `json:"pos"`
+		End      lexer.Position `json:"end"`
 	}
 
+	// A float literal, such as 1.5 or 1e9
 	NumericLiteral struct {
-		ExprStmt `json:"kind"` // Type should always be NumericLiteralNode
-		Value    float64       `json:"value"`
+		ExprStmt `json:"kind"`  // Type should always be NumericLiteralNode
+		Value    float64        `json:"value"`
+		Pos      lexer.Position `json:"pos"`
+		End      lexer.Position `json:"end"`
+	}
+
+	// A literal without a fraction or exponent, such as 42 or 0xFF
+	IntegerLiteral struct {
+		ExprStmt `json:"kind"`  // Type should always be IntegerLiteralNode
+		Value    int64          `json:"value"`
+		Pos      lexer.Position `json:"pos"`
+		End      lexer.Position `json:"end"`
 	}
 
 	NullLiteral struct {
-		ExprStmt `json:"kind"` // Type should always be NullLiteralNode
-		Value    string        `json:"value"` // value should always be null
+		ExprStmt `json:"kind"`  // Type should always be NullLiteralNode
+		Value    string         `json:"value"` // value should always be null
+		Pos      lexer.Position `json:"pos"`
+		End      lexer.Position `json:"end"`
 	}
 	Program struct {
 		Kind NodeType // Type should always be ProgramNode but I don't know how to do that in Go
 		Body []Stmt
+		Pos  lexer.Position `json:"pos"`
+		End  lexer.Position `json:"end"`
 	}
 
 	VarDeclaration struct {
-		Kind       NodeType `json:"kind"` // Type should always be VarDeclarationNode but I don't know how to do that in Go
-		Constant   bool     `json:"constant"`
-		Identifier string   `json:"string"`
-		Value      *Expr    `json:"value"` // Variables can be initialized without values
+		Kind       NodeType       `json:"kind"` // Type should always be VarDeclarationNode but I don't know how to do that in Go
+		Constant   bool           `json:"constant"`
+		Identifier string         `json:"string"`
+		Binding    Binding        `json:"binding"`
+		Value      *Expr          `json:"value"` // Variables can be initialized without values
+		Pos        lexer.Position `json:"pos"`
+		End        lexer.Position `json:"end"`
 	}
 
 	VarAssignmentExpr struct {
 		Kind     NodeType // Type should always be AssignmentNode but I don't know how to do that in Go
 		Assignee Expr     // This is important for the implementation of objects in supporting complex expressions
 		Value    Expr
+		Pos      lexer.Position `json:"pos"`
+		End      lexer.Position `json:"end"`
 	}
 
 	ObjectLiteral struct {
 		Kind       NodeType          `json:"kind"` // Type should always be ObjectLiteralNode
 		Properties []PropertyLiteral `json:"properties"`
+		Pos        lexer.Position    `json:"pos"`
+		End        lexer.Position    `json:"end"`
+	}
+
+	ArrayLiteral struct {
+		Kind     NodeType       `json:"kind"` // Type should always be ArrayLiteralNode
+		Elements []Expr         `json:"elements"`
+		Pos      lexer.Position `json:"pos"`
+		End      lexer.Position `json:"end"`
 	}
 
 	PropertyLiteral struct {
-		Kind  NodeType `json:"kind"` // Type should always be PropertyLiteralNode
-		Key   string   `json:"key"`
-		Value *Expr    `json:"value"` // Pointer so it can be nil
+		Kind    NodeType       `json:"kind"` // Type should always be PropertyLiteralNode
+		Key     string         `json:"key"`
+		Value   *Expr          `json:"value"`   // Pointer so it can be nil
+		Binding Binding        `json:"binding"` // of the variable { key } reads
+		Pos     lexer.Position `json:"pos"`
+		End     lexer.Position `json:"end"`
 	}
 
 	MemberExpr struct {
-		Kind     NodeType `json:"kind"` // Type should always be MemberExprNode
-		Object   Expr     `json:"object"`
-		Field    Expr     `json:"property"`
-		Computed bool     `json:"computed"`
+		Kind     NodeType       `json:"kind"` // Type should always be MemberExprNode
+		Object   Expr           `json:"object"`
+		Field    Expr           `json:"property"`
+		Computed bool           `json:"computed"`
+		Pos      lexer.Position `json:"pos"`
+		End      lexer.Position `json:"end"`
 	}
 
 	InternalFunctionCallExpr struct {
-		Kind   NodeType `json:"kind"` // Type should always be FunctionCallExprNode
-		Args   []Expr   `json:"args"`
-		Caller Expr     `json:"caller"`
+		Kind   NodeType       `json:"kind"` // Type should always be FunctionCallExprNode
+		Args   []Expr         `json:"args"`
+		Caller Expr           `json:"caller"`
+		Pos    lexer.Position `json:"pos"`
+		End    lexer.Position `json:"end"`
 	}
 
 	ComparisonExpr struct {
@@ -123,25 +186,108 @@ type (
 		Operator string   `json:"operator"`
 		Left     Expr
 		Right    Expr
+		Pos      lexer.Position `json:"pos"`
+		End      lexer.Position `json:"end"`
+	}
+
+	StringLiteral struct {
+		ExprStmt `json:"kind"`  // Type should always be StringLiteralNode
+		Value    string         `json:"value"`
+		Pos      lexer.Position `json:"pos"`
+		End      lexer.Position `json:"end"`
+	}
+
+	LogicalExpr struct {
+		Kind     NodeType       `json:"kind"`     // Type should always be LogicalExprNode
+		Operator string         `json:"operator"` // && or ||
+		Left     Expr           `json:"left"`
+		Right    Expr           `json:"right"` // only evaluated when Left does not decide the result
+		Pos      lexer.Position `json:"pos"`
+		End      lexer.Position `json:"end"`
+	}
+
+	UnaryExpr struct {
+		Kind     NodeType       `json:"kind"` // Type should always be UnaryExprNode
+		Operator string         `json:"operator"`
+		Operand  Expr           `json:"operand"`
+		Pos      lexer.Position `json:"pos"`
+		End      lexer.Position `json:"end"`
 	}
 
 	BooleanLiteral struct {
-		ExprStmt `json:"kind"` // Type should always be NumericLiteralNode
-		Value    bool          `json:"value"`
+		ExprStmt `json:"kind"`  // Type should always be NumericLiteralNode
+		Value    bool           `json:"value"`
+		Pos      lexer.Position `json:"pos"`
+		End      lexer.Position `json:"end"`
 	}
 
 	FunctionDeclaration struct {
-		Kind   NodeType `json:"kind"`
-		Params []string `json:"params"`
-		Name   string   `json:"name"`
-		Body   []Stmt   `json:"body"`
+		Kind     NodeType         `json:"kind"`
+		Params   []string         `json:"params"`
+		ParamPos []lexer.Position `json:"paramPos"` // where each of Params is named
+		Name     string           `json:"name"`
+		Body     []Stmt           `json:"body"`
+		Binding  Binding          `json:"binding"` // of the variable the function is declared as
+		Slots    int              `json:"slots"`   // variables in the scope of a call, parameters first. 0 if not resolved
+		Pos      lexer.Position   `json:"pos"`
+		End      lexer.Position   `json:"end"`
+	}
+
+	// Anonymous functions, both func (a, b) { ... } and (a, b) => a + b
+	FunctionExpr struct {
+		Kind     NodeType         `json:"kind"` // Type should always be FunctionExprNode
+		Params   []string         `json:"params"`
+		ParamPos []lexer.Position `json:"paramPos"` // see FunctionDeclaration
+		Body     []Stmt           `json:"body"`     // the body of an arrow function with an expression body is just that expression
+		Slots    int              `json:"slots"`    // see FunctionDeclaration
+		Pos      lexer.Position   `json:"pos"`
+		End      lexer.Position   `json:"end"`
 	}
 
 	BranchStmt struct {
-		Kind      NodeType `json:"kind"`
-		Condition Expr     `json:"condition"`
-		Body      []Stmt   `json:"body"`
-		Else      []Stmt   `json:"else"`
+		Kind      NodeType       `json:"kind"`
+		Condition Expr           `json:"condition"`
+		Body      []Stmt         `json:"body"`
+		Else      []Stmt         `json:"else"`
+		Pos       lexer.Position `json:"pos"`
+		End       lexer.Position `json:"end"`
+	}
+
+	ReturnStmt struct {
+		Kind  NodeType       `json:"kind"`  // Type should always be ReturnNode
+		Value *Expr          `json:"value"` // nil for a bare return
+		Pos   lexer.Position `json:"pos"`
+		End   lexer.Position `json:"end"`
+	}
+
+	WhileStmt struct {
+		Kind      NodeType       `json:"kind"` // Type should always be WhileNode
+		Condition Expr           `json:"condition"`
+		Body      []Stmt         `json:"body"`
+		Pos       lexer.Position `json:"pos"`
+		End       lexer.Position `json:"end"`
+	}
+
+	ForStmt struct {
+		Kind      NodeType       `json:"kind"`      // Type should always be ForNode
+		Init      *Stmt          `json:"init"`      // Each part of the loop header can be left out, so these are pointers
+		Condition *Expr          `json:"condition"` // A missing condition loops until break
+		Update    *Expr          `json:"update"`
+		Body      []Stmt         `json:"body"`
+		Pos       lexer.Position `json:"pos"`
+		End       lexer.Position `json:"end"`
+	}
+
+	BreakStmt struct {
+		Kind NodeType       `json:"kind"` // Type should always be BreakNode
+		Pos  lexer.Position `json:"pos"`
+		End  lexer.Position `json:"end"`
+	}
+
+	ContinueStmt struct {
+		Kind NodeType       `json:"kind"` // Type should always be ContinueNode
+		Pos  lexer.Position `json:"pos"`
+		End  lexer.Position `json:"end"`
 	}
 )
 
@@ -162,6 +308,10 @@ func (n NumericLiteral) GetKind() NodeType {
 	return NumericLiteralNode
 }
 
+func (i IntegerLiteral) GetKind() NodeType {
+	return IntegerLiteralNode
+}
+
 func (p Program) GetKind() NodeType {
 	return ProgramNode
 }
@@ -178,6 +328,10 @@ func (o ObjectLiteral) GetKind() NodeType {
 	return ObjectLiteralNode
 }
 
+func (a ArrayLiteral) GetKind() NodeType {
+	return ArrayLiteralNode
+}
+
 func (p PropertyLiteral) GetKind() NodeType {
 	return PropertyLiteralNode
 }
@@ -194,10 +348,26 @@ func (c ComparisonExpr) GetKind() NodeType {
 	return ComparisonExprNode
 }
 
+func (u UnaryExpr) GetKind() NodeType {
+	return UnaryExprNode
+}
+
+func (l LogicalExpr) GetKind() NodeType {
+	return LogicalExprNode
+}
+
+func (f FunctionExpr) GetKind() NodeType {
+	return FunctionExprNode
+}
+
 func (b BooleanLiteral) GetKind() NodeType {
 	return BooleanLiteralNode
 }
 
+func (s StringLiteral) GetKind() NodeType {
+	return StringLiteralNode
+}
+
 func (f FunctionDeclaration) GetKind() NodeType {
 	return FunctionDeclarationNode
 }
@@ -206,6 +376,235 @@ func (b BranchStmt) GetKind() NodeType {
 	return BranchNode
 }
 
+func (r ReturnStmt) GetKind() NodeType {
+	return ReturnNode
+}
+
+func (w WhileStmt) GetKind() NodeType {
+	return WhileNode
+}
+
+func (f ForStmt) GetKind() NodeType {
+	return ForNode
+}
+
+func (b BreakStmt) GetKind() NodeType {
+	return BreakNode
+}
+
+func (c ContinueStmt) GetKind() NodeType {
+	return ContinueNode
+}
+
+// Implement source positions
+func (b BinaryExpr) GetPos() lexer.Position {
+	return b.Pos
+}
+
+func (b BinaryExpr) GetEnd() lexer.Position {
+	return b.End
+}
+
+func (i Ident) GetPos() lexer.Position {
+	return i.Pos
+}
+
+func (i Ident) GetEnd() lexer.Position {
+	return i.End
+}
+
+func (n NumericLiteral) GetPos() lexer.Position {
+	return n.Pos
+}
+
+func (n NumericLiteral) GetEnd() lexer.Position {
+	return n.End
+}
+
+func (i IntegerLiteral) GetPos() lexer.Position {
+	return i.Pos
+}
+
+func (i IntegerLiteral) GetEnd() lexer.Position {
+	return i.End
+}
+
+func (n NullLiteral) GetPos() lexer.Position {
+	return n.Pos
+}
+
+func (n NullLiteral) GetEnd() lexer.Position {
+	return n.End
+}
+
+func (p Program) GetPos() lexer.Position {
+	return p.Pos
+}
+
+func (p Program) GetEnd() lexer.Position {
+	return p.End
+}
+
+func (v VarDeclaration) GetPos() lexer.Position {
+	return v.Pos
+}
+
+func (v VarDeclaration) GetEnd() lexer.Position {
+	return v.End
+}
+
+func (v VarAssignmentExpr) GetPos() lexer.Position {
+	return v.Pos
+}
+
+func (v VarAssignmentExpr) GetEnd() lexer.Position {
+	return v.End
+}
+
+func (o ObjectLiteral) GetPos() lexer.Position {
+	return o.Pos
+}
+
+func (o ObjectLiteral) GetEnd() lexer.Position {
+	return o.End
+}
+
+func (a ArrayLiteral) GetPos() lexer.Position {
+	return a.Pos
+}
+
+func (a ArrayLiteral) GetEnd() lexer.Position {
+	return a.End
+}
+
+func (p PropertyLiteral) GetPos() lexer.Position {
+	return p.Pos
+}
+
+func (p PropertyLiteral) GetEnd() lexer.Position {
+	return p.End
+}
+
+func (m MemberExpr) GetPos() lexer.Position {
+	return m.Pos
+}
+
+func (m MemberExpr) GetEnd() lexer.Position {
+	return m.End
+}
+
+func (f InternalFunctionCallExpr) GetPos() lexer.Position {
+	return f.Pos
+}
+
+func (f InternalFunctionCallExpr) GetEnd() lexer.Position {
+	return f.End
+}
+
+func (c ComparisonExpr) GetPos() lexer.Position {
+	return c.Pos
+}
+
+func (c ComparisonExpr) GetEnd() lexer.Position {
+	return c.End
+}
+
+func (u UnaryExpr) GetPos() lexer.Position {
+	return u.Pos
+}
+
+func (u UnaryExpr) GetEnd() lexer.Position {
+	return u.End
+}
+
+func (l LogicalExpr) GetPos() lexer.Position {
+	return l.Pos
+}
+
+func (l LogicalExpr) GetEnd() lexer.Position {
+	return l.End
+}
+
+func (f FunctionExpr) GetPos() lexer.Position {
+	return f.Pos
+}
+
+func (f FunctionExpr) GetEnd() lexer.Position {
+	return f.End
+}
+
+func (b BooleanLiteral) GetPos() lexer.Position {
+	return b.Pos
+}
+
+func (b BooleanLiteral) GetEnd() lexer.Position {
+	return b.End
+}
+
+func (s StringLiteral) GetPos() lexer.Position {
+	return s.Pos
+}
+
+func (s StringLiteral) GetEnd() lexer.Position {
+	return s.End
+}
+
+func (f FunctionDeclaration) GetPos() lexer.Position {
+	return f.Pos
+}
+
+func (f FunctionDeclaration) GetEnd() lexer.Position {
+	return f.End
+}
+
+func (b BranchStmt) GetPos() lexer.Position {
+	return b.Pos
+}
+
+func (b BranchStmt) GetEnd() lexer.Position {
+	return b.End
+}
+
+func (r ReturnStmt) GetPos() lexer.Position {
+	return r.Pos
+}
+
+func (r ReturnStmt) GetEnd() lexer.Position {
+	return r.End
+}
+
+func (w WhileStmt) GetPos() lexer.Position {
+	return w.Pos
+}
+
+func (w WhileStmt) GetEnd() lexer.Position {
+	return w.End
+}
+
+func (f ForStmt) GetPos() lexer.Position {
+	return f.Pos
+}
+
+func (f ForStmt) GetEnd() lexer.Position {
+	return f.End
+}
+
+func (b BreakStmt) GetPos() lexer.Position {
+	return b.Pos
+}
+
+func (b BreakStmt) GetEnd() lexer.Position {
+	return b.End
+}
+
+func (c ContinueStmt) GetPos() lexer.Position {
+	return c.Pos
+}
+
+func (c ContinueStmt) GetEnd() lexer.Position {
+	return c.End
+}
+
 // Implement expression and statements
 func (i Ident) expressionNode() {}
 func (i Ident) statementNode()  {}
@@ -219,6 +618,9 @@ func (b BinaryExpr) statementNode()  {}
 func (n NumericLiteral) expressionNode() {}
 func (n NumericLiteral) statementNode()  {}
 
+func (i IntegerLiteral) expressionNode() {}
+func (i IntegerLiteral) statementNode()  {}
+
 func (n NullLiteral) expressionNode() {}
 func (n NullLiteral) statementNode()  {}
 
@@ -232,6 +634,9 @@ func (v VarAssignmentExpr) expressionNode() {}
 func (o ObjectLiteral) expressionNode() {}
 func (o ObjectLiteral) statementNode()  {}
 
+func (a ArrayLiteral) expressionNode() {}
+func (a ArrayLiteral) statementNode()  {}
+
 func (p PropertyLiteral) expressionNode() {}
 func (p PropertyLiteral) statementNode()  {}
 
@@ -244,13 +649,35 @@ func (f InternalFunctionCallExpr) statementNode()  {}
 func (c ComparisonExpr) expressionNode() {}
 func (c ComparisonExpr) statementNode()  {}
 
+func (u UnaryExpr) expressionNode() {}
+func (u UnaryExpr) statementNode()  {}
+
+func (l LogicalExpr) expressionNode() {}
+func (l LogicalExpr) statementNode()  {}
+
+func (f FunctionExpr) expressionNode() {}
+func (f FunctionExpr) statementNode()  {}
+
 func (b BooleanLiteral) expressionNode() {}
 func (b BooleanLiteral) statementNode()  {}
 
+func (s StringLiteral) expressionNode() {}
+func (s StringLiteral) statementNode()  {}
+
 func (f FunctionDeclaration) statementNode() {}
 
 func (b BranchStmt) statementNode() {}
 
+func (r ReturnStmt) statementNode() {}
+
+func (w WhileStmt) statementNode() {}
+
+func (f ForStmt) statementNode() {}
+
+func (b BreakStmt) statementNode() {}
+
+func (c ContinueStmt) statementNode() {}
+
 func PrintAST(stmt Stmt) {
 	bytes, err := json.MarshalIndent(stmt, "", "    ")
 	if err != nil {
@@ -258,17 +685,28 @@ func PrintAST(stmt Stmt) {
 	}
 	str := string(bytes)
 
+	str = replaceStrings(FunctionExprNode, "FunctionExpr", str)
+	str = replaceStrings(LogicalExprNode, "LogicalExpr", str)
+	str = replaceStrings(UnaryExprNode, "UnaryExpr", str)
 	str = replaceStrings(ComparisonExprNode, "ComparisonExpr", str)
 	str = replaceStrings(InternalFunctionCallExprNode, "InternalFunctionCallExpr", str)
 	str = replaceStrings(MemberExprNode, "MemberExpr", str)
 	str = replaceStrings(AssignmentExprNode, "AssignmentExpr", str)
 	str = replaceStrings(BinaryExprNode, "BinaryExpr", str)
+	str = replaceStrings(ArrayLiteralNode, "ArrayLiteral", str)
+	str = replaceStrings(StringLiteralNode, "StringLiteral", str)
 	str = replaceStrings(BooleanLiteralNode, "BooleanLiteral", str)
 	str = replaceStrings(ObjectLiteralNode, "ObjectLiteral", str)
 	str = replaceStrings(PropertyLiteralNode, "PropertyLiteral", str)
 	str = replaceStrings(IdentifierNode, "Identifier", str)
 	str = replaceStrings(NullLiteralNode, "NullLiteral", str)
+	str = replaceStrings(IntegerLiteralNode, "IntegerLiteral", str)
 	str = replaceStrings(NumericLiteralNode, "NumericLiteral", str)
+	str = replaceStrings(ContinueNode, "ContinueStmt", str)
+	str = replaceStrings(BreakNode, "BreakStmt", str)
+	str = replaceStrings(ForNode, "ForStmt", str)
+	str = replaceStrings(WhileNode, "WhileStmt", str)
+	str = replaceStrings(ReturnNode, "ReturnStmt", str)
 	str = replaceStrings(BranchNode, "BranchStmt", str)
 	str = replaceStrings(FunctionDeclarationNode, "FunctionDeclaration", str)
 	str = replaceStrings(VarDeclarationNode, "VarDeclaration", str)
@@ -0,0 +1,30 @@
+package parser
+
+import (
+	"QuonkScript/lexer"
+	"fmt"
+)
+
+// ParseError is a single syntax error found while producing an AST
+type ParseError struct {
+	Message  string
+	Token    lexer.Token       // the offending token
+	Expected []lexer.TokenType // token types that would have been accepted here, empty if anything else was wrong
+	Pos      lexer.Position
+}
+
+func (e ParseError) Error() string {
+	return fmt.Sprintf("Honk! %s: %s", e.Pos, e.Message)
+}
+
+// describes a token for use in an error message
+func describeToken(token lexer.Token) string {
+	switch token.Type {
+	case lexer.EOF:
+		return "end of file"
+	case lexer.Illegal:
+		return fmt.Sprintf("unrecognized character '%s'", token.Value)
+	default:
+		return fmt.Sprintf("'%s'", token.Value)
+	}
+}
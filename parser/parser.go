@@ -2,13 +2,20 @@ package parser
 
 import (
 	"QuonkScript/lexer"
-	"encoding/json"
 	"fmt"
+	"math"
 	"strconv"
+	"strings"
 )
 
 type Parser struct {
 	tokens []lexer.Token
+	last   lexer.Token  // most recently eaten token, used to find where a node ends
+	depth  int          // how many { we are currently inside of, used to resynchronise after an error
+	errors []ParseError // errors found so far while producing the current AST
+
+	functionDepth int // how many function bodies we are currently inside of
+	loopDepth     int // how many loop bodies we are currently inside of, reset inside of function bodies
 }
 
 // returns first token in tokens array
@@ -16,38 +23,155 @@ func (P *Parser) at() lexer.Token {
 	return P.tokens[0]
 }
 
+// returns the token after the first token in tokens array
+func (P *Parser) peek() lexer.Token {
+	if len(P.tokens) < 2 {
+		return P.at() // EOF
+	}
+	return P.tokens[1]
+}
+
 // removes first from tokens array and returns it
 func (P *Parser) eat() lexer.Token {
 	// Pull out first token
 	prev := P.at()
+	// Never remove EOF so that P.at() is always safe
+	if prev.Type == lexer.EOF {
+		return prev
+	}
 	// Remove prev
 	P.tokens = P.tokens[1:]
+	P.last = prev
+
+	if prev.Type == lexer.OpenCurlyBracket {
+		P.depth++
+	} else if prev.Type == lexer.CloseCurlyBracket && P.depth > 0 {
+		P.depth--
+	}
 
 	return prev
 }
 
+// eats the first token if it is of the expected type, otherwise bails out of the current statement with a ParseError
 func (P *Parser) eatExpected(expected lexer.TokenType, err string) lexer.Token {
-	prev := P.at()
-	P.tokens = P.tokens[1:]
-
-	if prev.Type != lexer.TokenType(expected) {
-		panic(err)
+	if P.at().Type != expected {
+		P.fail(ParseError{
+			Message:  fmt.Sprintf("%s, found %s", err, describeToken(P.at())),
+			Token:    P.at(),
+			Expected: []lexer.TokenType{expected},
+			Pos:      P.at().Pos,
+		})
 	}
-	return prev
+	return P.eat()
 }
 
-// lexes, tokenizes, and produces a Program AST
-func (P *Parser) ProduceAST(src string) Program {
+// abandons the statement currently being parsed, ParseStatementRecover will record err and resynchronise
+func (P *Parser) fail(err ParseError) {
+	panic(err)
+}
+
+// records an error that does not stop the current statement from being parsed
+func (P *Parser) report(err ParseError) {
+	P.errors = append(P.errors, err)
+}
+
+// records an error at token in a statement that is otherwise well formed, so parsing carries on without resynchronising
+func (P *Parser) reportAt(token lexer.Token, message string, expected ...lexer.TokenType) {
+	P.report(ParseError{Message: message, Token: token, Expected: expected, Pos: token.Pos})
+}
+
+// lexes, tokenizes, and produces a Program AST along with every syntax error found in src
+func (P *Parser) ProduceAST(src string) (Program, []ParseError) {
 	// Create token array
 	P.tokens = lexer.Tokenize(src)
-	program := Program{Kind: ProgramNode, Body: make([]Stmt, 0)}
+	P.depth = 0
+	P.functionDepth = 0
+	P.loopDepth = 0
+	P.errors = make([]ParseError, 0)
+	program := Program{Kind: ProgramNode, Body: make([]Stmt, 0), Pos: P.at().Pos}
 
 	for P.NotEOF() {
 		// Push expressions onto body
-		program.Body = append(program.Body, P.ParseStatement())
+		if stmt := P.ParseStatementRecover(); stmt != nil {
+			program.Body = append(program.Body, stmt)
+		}
 	}
+	program.End = P.at().End
+
+	return program, P.errors
+}
+
+// Parses a statement. If the statement has a syntax error, the error is recorded and
+// the parser skips ahead to the next statement boundary so parsing can carry on. Returns nil in that case
+func (P *Parser) ParseStatementRecover() (stmt Stmt) {
+	depth, remaining := P.depth, len(P.tokens)
+
+	defer func() {
+		if r := recover(); r != nil {
+			err, ok := r.(ParseError)
+			if !ok {
+				// Not ours, this is a bug in the parser
+				panic(r)
+			}
+			P.report(err)
+			P.synchronise(depth)
+
+			// Always make progress, otherwise a stray } at the top level would loop forever
+			if len(P.tokens) == remaining {
+				P.eat()
+			}
+			stmt = nil
+		}
+	}()
 
-	return program
+	return P.ParseStatement()
+}
+
+// skips tokens until the end of the statement that started at the given brace depth.
+// A statement ends at a ; or at a } that closes a block opened by the statement. A } that
+// closes the enclosing block is left for the enclosing block to eat
+func (P *Parser) synchronise(depth int) {
+	for P.NotEOF() {
+		switch P.at().Type {
+		case lexer.Semicolon:
+			if P.depth <= depth {
+				P.eat()
+				return
+			}
+		case lexer.Mut, lexer.Const, lexer.Func, lexer.If, lexer.Return, lexer.While, lexer.For, lexer.Break, lexer.Continue:
+			// These can only start a statement, so the broken statement must have ended already
+			if P.depth <= depth {
+				return
+			}
+		case lexer.CloseCurlyBracket:
+			if P.depth <= depth {
+				return
+			}
+			P.eat()
+			if P.depth == depth {
+				// Block belonging to the statement was closed, allow an optional trailing semicolon
+				if P.at().Type == lexer.Semicolon {
+					P.eat()
+				}
+				return
+			}
+			continue
+		}
+		P.eat()
+	}
+}
+
+// parses statements until the closing } of a block, does not eat the }
+func (P *Parser) parseBlockBody() []Stmt {
+	body := make([]Stmt, 0)
+
+	for P.NotEOF() && P.at().Type != lexer.CloseCurlyBracket {
+		if stmt := P.ParseStatementRecover(); stmt != nil {
+			body = append(body, stmt)
+		}
+	}
+
+	return body
 }
 
 // returns whether head of token array is EOF
@@ -63,23 +187,48 @@ func (P *Parser) ParseStatement() Stmt {
 	case lexer.Const:
 		return P.ParseVarDeclaration()
 	case lexer.Func:
-		return P.ParseFunctionDeclaration()
+		// func followed by a name is a declaration, anything else is an anonymous function expression
+		if P.peek().Type == lexer.Identifier {
+			return P.ParseFunctionDeclaration()
+		}
+		return P.parseExprStmt()
 	case lexer.If:
 		return P.ParseBranchStmt()
+	case lexer.Return:
+		return P.ParseReturnStmt()
+	case lexer.While:
+		return P.ParseWhileStmt()
+	case lexer.For:
+		return P.ParseForStmt()
+	case lexer.Break:
+		fallthrough
+	case lexer.Continue:
+		return P.ParseLoopControlStmt()
 	default:
-		return P.ParseExpr()
+		return P.parseExprStmt()
 	}
 }
 
+func (P *Parser) parseExprStmt() Stmt {
+	expr := P.ParseExpr()
+	// Semicolons are optional after expression statements, so i = i + 1; reads the same as a declaration
+	if P.at().Type == lexer.Semicolon {
+		P.eat()
+	}
+	return expr
+}
+
 // Parse Expr, starts parsing at highest implemented level of following
 // orders of precedence:
 //
 //		AssignmentExpr
 //		ObjectExpr
-//		ChainedComparisonExpr
+//		LogicalOrExpr
+//		LogicalAndExpr
 //		ComparisonExpr
 //		AdditiveExpr
 //		MultiplicativeExpr
+//		UnaryExpr
 //	 	FunctionCallExpr
 //		MemberExpr
 //		PrimaryExpr
@@ -98,7 +247,7 @@ func (P *Parser) ParseAssignmentExpr() Expr {
 		P.eat()                          // advance past equals token
 		value := P.ParseAssignmentExpr() // we want to allow chaining so we must call recursively
 
-		return VarAssignmentExpr{Value: value, Assignee: left, Kind: AssignmentExprNode}
+		return VarAssignmentExpr{Value: value, Assignee: left, Kind: AssignmentExprNode, Pos: left.GetPos(), End: value.GetEnd()}
 	}
 
 	return left
@@ -108,40 +257,41 @@ func (P *Parser) ParseAssignmentExpr() Expr {
 // Also kicks off ParseAdditiveExpr()
 func (P *Parser) ParseObjectExpr() Expr {
 	if P.at().Type != lexer.OpenCurlyBracket {
-		return P.ParseChainedLogicalExpr() // If we do not find an open brace, proceed on
+		return P.ParseLogicalOrExpr() // If we do not find an open brace, proceed on
 	}
 
-	P.eat() // advance past open brace
+	pos := P.eat().Pos // advance past open brace
 	properties := make([]PropertyLiteral, 0)
 
 	for P.NotEOF() && P.at().Type != lexer.CloseCurlyBracket {
-		key := P.eatExpected(lexer.Identifier, "Honk! Expected field name following bracket in object literal").Value
+		keyToken := P.eatExpected(lexer.Identifier, "Expected field name following bracket in object literal")
+		key := keyToken.Value
 
 		switch P.at().Type {
 		// Allow shorthand {key, }
 		case lexer.Comma:
 			P.eat() // Advance past comma
 			// append property with no value
-			properties = append(properties, PropertyLiteral{Value: nil, Kind: PropertyLiteralNode, Key: key})
+			properties = append(properties, PropertyLiteral{Value: nil, Kind: PropertyLiteralNode, Key: key, Pos: keyToken.Pos, End: keyToken.End})
 		// Allow shorthand { key }
 		case lexer.CloseCurlyBracket:
 			// append property with no value
-			properties = append(properties, PropertyLiteral{Value: nil, Kind: PropertyLiteralNode, Key: key})
+			properties = append(properties, PropertyLiteral{Value: nil, Kind: PropertyLiteralNode, Key: key, Pos: keyToken.Pos, End: keyToken.End})
 		// { key: value }
 		default:
-			P.eatExpected(lexer.Colon, "Honk! Expected colon following property name in object literal")
+			P.eatExpected(lexer.Colon, "Expected colon following property name in object literal")
 			val := P.ParseExpr() // Allow any expression
 			// Append property with value
-			properties = append(properties, PropertyLiteral{Value: &val, Kind: PropertyLiteralNode, Key: key})
+			properties = append(properties, PropertyLiteral{Value: &val, Kind: PropertyLiteralNode, Key: key, Pos: keyToken.Pos, End: val.GetEnd()})
 
 			if P.at().Type != lexer.CloseCurlyBracket {
-				P.eatExpected(lexer.Comma, "Honk! Expected comma or closing brace at end of object literal")
+				P.eatExpected(lexer.Comma, "Expected comma or closing brace at end of object literal")
 			}
 		}
 
 	}
-	P.eatExpected(lexer.CloseCurlyBracket, "Honk! Expected closing bracket for object literal")
-	return ObjectLiteral{Kind: ObjectLiteralNode, Properties: properties}
+	end := P.eatExpected(lexer.CloseCurlyBracket, "Expected closing bracket for object literal").End
+	return ObjectLiteral{Kind: ObjectLiteralNode, Properties: properties, Pos: pos, End: end}
 }
 
 func (P *Parser) ParseComparisonExpr() Expr {
@@ -151,24 +301,49 @@ func (P *Parser) ParseComparisonExpr() Expr {
 		operator := P.eat().Value
 		right := P.ParseAdditiveExpr()
 
-		left = ComparisonExpr{Kind: ComparisonExprNode, Left: left, Right: right, Operator: operator}
+		left = ComparisonExpr{Kind: ComparisonExprNode, Left: left, Right: right, Operator: operator, Pos: left.GetPos(), End: right.GetEnd()}
 	}
 
 	return left
 }
 
-func (P *Parser) ParseChainedLogicalExpr() Expr {
+// Parses || chains with left to right precedence, || binds looser than && so a || b && c is a || (b && c)
+// Also kicks off ParseLogicalAndExpr()
+func (P *Parser) ParseLogicalOrExpr() Expr {
+	left := P.ParseLogicalAndExpr()
+
+	for P.at().Type == lexer.Or {
+		operator := P.eat().Value
+		right := P.ParseLogicalAndExpr()
+
+		left = LogicalExpr{
+			Kind:     LogicalExprNode,
+			Left:     left,
+			Right:    right,
+			Operator: operator,
+			Pos:      left.GetPos(),
+			End:      right.GetEnd(),
+		}
+	}
+	return left
+}
+
+// Parses && chains with left to right precedence
+// Also kicks off ParseComparisonExpr()
+func (P *Parser) ParseLogicalAndExpr() Expr {
 	left := P.ParseComparisonExpr()
 
-	for P.at().Type == lexer.And || P.at().Type == lexer.Or {
+	for P.at().Type == lexer.And {
 		operator := P.eat().Value
 		right := P.ParseComparisonExpr()
 
-		left = ComparisonExpr{
-			Kind:     ComparisonExprNode,
+		left = LogicalExpr{
+			Kind:     LogicalExprNode,
 			Left:     left,
 			Right:    right,
 			Operator: operator,
+			Pos:      left.GetPos(),
+			End:      right.GetEnd(),
 		}
 	}
 	return left
@@ -185,31 +360,48 @@ func (P *Parser) ParseAdditiveExpr() Expr {
 		right := P.ParseMultiplicativeExpr()
 
 		// This bubbles up the expr
-		left = BinaryExpr{ExprStmt: ExprStmt{Kind: BinaryExprNode}, Left: left, Right: right, Operator: operator}
+		left = BinaryExpr{ExprStmt: ExprStmt{Kind: BinaryExprNode}, Left: left, Right: right, Operator: operator, Pos: left.GetPos(), End: right.GetEnd()}
 	}
 	return left
 }
 
 // Parses multiplicative expressions with left to right precendence for order of operations.
-// Function kicks off ParseCallMemberExpr
+// Function kicks off ParseUnaryExpr
 func (P *Parser) ParseMultiplicativeExpr() Expr {
-	left := P.ParseCallMemberExpr()
+	left := P.ParseUnaryExpr()
 
 	for P.at().Value == "*" || P.at().Value == "/" || P.at().Value == "%" {
 
 		operator := P.eat().Value
-		right := P.ParseCallMemberExpr()
+		right := P.ParseUnaryExpr()
 
 		// This bubbles up the tree
-		left = BinaryExpr{ExprStmt: ExprStmt{Kind: BinaryExprNode}, Left: left, Right: right, Operator: operator}
+		left = BinaryExpr{ExprStmt: ExprStmt{Kind: BinaryExprNode}, Left: left, Right: right, Operator: operator, Pos: left.GetPos(), End: right.GetEnd()}
 	}
 	return left
 }
 
+// Parses prefix operators -x, +x and !x. These bind tighter than any binary operator
+// so -a * b is (-a) * b, but looser than calls and member access so -a.b() is -(a.b())
+// Also kicks off ParseCallMemberExpr
+func (P *Parser) ParseUnaryExpr() Expr {
+	token := P.at()
+	isSign := token.Type == lexer.BinaryOperator && (token.Value == "-" || token.Value == "+")
+
+	if token.Type == lexer.Bang || isSign {
+		P.eat()
+		operand := P.ParseUnaryExpr() // allow stacking like !!x and - -x
+
+		return UnaryExpr{Kind: UnaryExprNode, Operator: token.Value, Operand: operand, Pos: token.Pos, End: operand.GetEnd()}
+	}
+
+	return P.ParseCallMemberExpr()
+}
+
 // This function is different as it takes in an Expr argument
 func (P *Parser) ParseFunctionCallExpr(caller Expr) Expr {
 	args := P.ParseArguments()
-	var callExpr Expr = InternalFunctionCallExpr{Kind: InternalFunctionCallExprNode, Caller: caller, Args: args} // no walrus here since we need callExpr to just be an Expr
+	var callExpr Expr = InternalFunctionCallExpr{Kind: InternalFunctionCallExprNode, Caller: caller, Args: args, Pos: caller.GetPos(), End: P.last.End} // no walrus here since we need callExpr to just be an Expr
 
 	// This allows us to recursively chain function calls
 	if P.at().Type == lexer.OpenParen {
@@ -237,7 +429,7 @@ func (P *Parser) ParseMemberExpr() Expr {
 			field = P.ParsePrimaryExpr()
 
 			if field.GetKind() != IdentifierNode {
-				panic("Honk! Attempt to reference object field with something other than an identifier")
+				P.fail(ParseError{Message: "Attempt to reference object field with something other than an identifier", Token: P.last, Expected: []lexer.TokenType{lexer.Identifier}, Pos: field.GetPos()})
 			}
 
 		} else {
@@ -245,46 +437,116 @@ func (P *Parser) ParseMemberExpr() Expr {
 			computed = true
 			// this allows obj[computed]
 			field = P.ParseExpr()
-			P.eatExpected(lexer.CloseSquareBracket, "Honk! Expected closing bracket for object field access")
+			P.eatExpected(lexer.CloseSquareBracket, "Expected closing bracket for object field access")
 		}
-		obj = MemberExpr{Kind: MemberExprNode, Field: field, Computed: computed, Object: obj}
+		obj = MemberExpr{Kind: MemberExprNode, Field: field, Computed: computed, Object: obj, Pos: obj.GetPos(), End: P.last.End}
 	}
 	return obj
 }
 
+// converts a Number token, which the lexer has already checked is well formed. Numbers with a fraction or an exponent
+// are floats, every other number is an integer
+func parseNumber(token lexer.Token) (Expr, error) {
+	digits := strings.ReplaceAll(token.Value, "_", "")
+
+	base := 10
+	if len(digits) > 2 && digits[0] == '0' {
+		switch digits[1] {
+		case 'x', 'X':
+			base = 16
+		case 'b', 'B':
+			base = 2
+		}
+	}
+
+	if base != 10 || !strings.ContainsAny(digits, ".eE") {
+		if base != 10 {
+			digits = digits[2:]
+		}
+		val, err := strconv.ParseInt(digits, base, 64)
+		if err != nil {
+			return nil, fmt.Errorf("Integer %s is too large, integers can be at most %d", token.Value, int64(math.MaxInt64))
+		}
+		return IntegerLiteral{Value: val, ExprStmt: ExprStmt{Kind: IntegerLiteralNode}, Pos: token.Pos, End: token.End}, nil
+	}
+
+	val, err := strconv.ParseFloat(digits, 64)
+	if err != nil {
+		return nil, fmt.Errorf("Number %s is too large", token.Value)
+	}
+	return NumericLiteral{Value: val, ExprStmt: ExprStmt{Kind: NumericLiteralNode}, Pos: token.Pos, End: token.End}, nil
+}
+
 // parse primary expression, bottom of call stack
 func (P *Parser) ParsePrimaryExpr() Expr {
-	token := P.at().Type
+	token := P.at()
 
-	switch token {
+	switch token.Type {
 	case lexer.Null:
 		P.eat()
-		return NullLiteral{ExprStmt: ExprStmt{Kind: NullLiteralNode}, Value: "null"}
+		return NullLiteral{ExprStmt: ExprStmt{Kind: NullLiteralNode}, Value: "null", Pos: token.Pos, End: token.End}
 	case lexer.Number:
-		val, _ := strconv.ParseFloat(P.eat().Value, 64)
-		return NumericLiteral{Value: val, ExprStmt: ExprStmt{Kind: NumericLiteralNode}}
+		literal, err := parseNumber(P.eat())
+		if err != nil {
+			P.fail(ParseError{Message: err.Error(), Token: token, Pos: token.Pos})
+		}
+		return literal
+	case lexer.String:
+		P.eat()
+		return StringLiteral{Value: token.Value, ExprStmt: ExprStmt{Kind: StringLiteralNode}, Pos: token.Pos, End: token.End}
 	case lexer.Identifier:
-		return Ident{Symbol: P.eat().Value, ExprStmt: ExprStmt{Kind: IdentifierNode}}
+		// x => x * 2
+		if P.peek().Type == lexer.Arrow {
+			return P.ParseArrowFunction()
+		}
+		return Ident{Symbol: P.eat().Value, ExprStmt: ExprStmt{Kind: IdentifierNode}, Pos: token.Pos, End: token.End}
 	case lexer.True:
 		P.eat()
-		return BooleanLiteral{Value: true, ExprStmt: ExprStmt{Kind: BooleanLiteralNode}}
+		return BooleanLiteral{Value: true, ExprStmt: ExprStmt{Kind: BooleanLiteralNode}, Pos: token.Pos, End: token.End}
 	case lexer.False:
 		P.eat()
-		return BooleanLiteral{Value: false, ExprStmt: ExprStmt{Kind: BooleanLiteralNode}}
+		return BooleanLiteral{Value: false, ExprStmt: ExprStmt{Kind: BooleanLiteralNode}, Pos: token.Pos, End: token.End}
 	case lexer.OpenParen:
+		if P.isArrowFunction() {
+			return P.ParseArrowFunction()
+		}
 		P.eat() // eat the opening paren
 		val := P.ParseExpr()
 		P.eatExpected(lexer.CloseParen, "Missing close paren") // eat closing paren
 		return val
+	case lexer.OpenSquareBracket:
+		return P.ParseArrayLiteral()
+	case lexer.Func:
+		return P.ParseFunctionExpr()
 
+	case lexer.Illegal:
+		message := token.Error
+		if message == "" {
+			message = fmt.Sprintf("Unrecognized character '%s'", token.Value)
+		}
+		P.fail(ParseError{Message: message, Token: token, Pos: token.Pos})
+		return nil // unreachable, fail does not return
 	default:
-		bytes, err := json.Marshal(P.at())
-		if err == nil {
-			fmt.Println(string(bytes))
+		P.fail(ParseError{Message: fmt.Sprintf("Unexpected %s found during parsing", describeToken(token)), Token: token, Pos: token.Pos})
+		return nil // unreachable, fail does not return
+	}
+}
+
+// Parses array literals like [1, 2, 3], a trailing comma is allowed
+func (P *Parser) ParseArrayLiteral() Expr {
+	pos := P.eat().Pos // advance past open bracket
+	elements := make([]Expr, 0)
+
+	for P.NotEOF() && P.at().Type != lexer.CloseSquareBracket {
+		elements = append(elements, P.ParseExpr())
+
+		if P.at().Type != lexer.CloseSquareBracket {
+			P.eatExpected(lexer.Comma, "Expected comma or closing bracket after array element")
 		}
-		// Do something better than panicking here
-		panic("Unexpeceted token found during parsing")
 	}
+	end := P.eatExpected(lexer.CloseSquareBracket, "Expected closing bracket for array literal").End
+
+	return ArrayLiteral{Kind: ArrayLiteralNode, Elements: elements, Pos: pos, End: end}
 }
 
 // Parses Object Member expressions with left to right precedence, can parse members recursively
@@ -301,13 +563,13 @@ func (P *Parser) ParseCallMemberExpr() Expr {
 // arguments are not parameters, args are just expressions
 func (P *Parser) ParseArguments() []Expr {
 	// To get here, P.at() is an open paren but this doesn't hurt
-	P.eatExpected(lexer.OpenParen, "Honk! Expected parenthesis after function call")
+	P.eatExpected(lexer.OpenParen, "Expected parenthesis after function call")
 	args := make([]Expr, 0)
 	// if the next token is anything other than
 	if P.at().Type != lexer.CloseParen {
 		args = P.ParseArgumentList()
 	}
-	P.eatExpected(lexer.CloseParen, "Honk! Expected closing parenthesis for function call")
+	P.eatExpected(lexer.CloseParen, "Expected closing parenthesis for function call")
 	return args
 }
 
@@ -325,84 +587,266 @@ func (P *Parser) ParseArgumentList() []Expr {
 	// No need to eatExpected() here as we do that in the calling function
 }
 
+// This function parses the parameters of a function declaration
+// unlike arguments, parameters must be identifiers. Returns their names and where each of them is
+func (P *Parser) ParseParams() ([]string, []lexer.Position) {
+	P.eatExpected(lexer.OpenParen, "Expected opening ( before parameters of function declaration")
+	params := make([]string, 0)
+	positions := make([]lexer.Position, 0)
+
+	if P.at().Type != lexer.CloseParen {
+		for {
+			param := P.eatExpected(lexer.Identifier, "Expected parameter name in function declaration")
+			params = append(params, param.Value)
+			positions = append(positions, param.Pos)
+
+			if P.at().Type != lexer.Comma {
+				break
+			}
+			P.eat()
+		}
+	}
+	P.eatExpected(lexer.CloseParen, "Expected closing ) after parameters of function declaration")
+
+	return params, positions
+}
+
 // Parses variable declaration expr stmt
 func (P *Parser) ParseVarDeclaration() Stmt {
 	// eat advances
-	isConstant := P.eat().Type == lexer.Const
+	keyword := P.eat()
+	isConstant := keyword.Type == lexer.Const
 	// eatExpected advances
-	identifier := P.eatExpected(lexer.Identifier, "Expected variable name").Value
+	identifierToken := P.eatExpected(lexer.Identifier, "Expected variable name")
+	identifier := identifierToken.Value
 
 	if P.at().Type == lexer.Semicolon {
 		P.eat() // Advance
 		if isConstant {
-			panic("Constant variables must be initialized")
+			P.reportAt(identifierToken, fmt.Sprintf("Constant variable %s must be initialized", identifier), lexer.Equals)
 		}
 		// Mutable variable declaration Node
-		return VarDeclaration{Kind: VarDeclarationNode, Identifier: identifier, Constant: false, Value: nil}
+		return VarDeclaration{Kind: VarDeclarationNode, Identifier: identifier, Constant: false, Value: nil, Pos: keyword.Pos, End: P.last.End}
 	}
 
 	P.eatExpected(lexer.Equals, "Expected equals following variable name in declaration")
 	value := P.ParseExpr()
 	// is this pointer fucked?
-	declaration := VarDeclaration{Kind: VarDeclarationNode, Value: &value, Constant: isConstant, Identifier: identifier}
-	P.eatExpected(lexer.Semicolon, "Missing semicolon following variable declaration")
+	end := P.eatExpected(lexer.Semicolon, "Missing semicolon following variable declaration").End
+	declaration := VarDeclaration{Kind: VarDeclarationNode, Value: &value, Constant: isConstant, Identifier: identifier, Pos: keyword.Pos, End: end}
 	return declaration
 }
 
-// todo: check if P.at() is paren to handle anonymous function?
+// Parses named function declarations, anonymous functions are parsed by ParseFunctionExpr
 func (P *Parser) ParseFunctionDeclaration() Stmt {
-	P.eat() // advance past func token
+	pos := P.eat().Pos // advance past func token
 
-	name := P.eatExpected(lexer.Identifier, "Honk! Expected function name in declaration").Value
-	args := P.ParseArguments()
+	name := P.eatExpected(lexer.Identifier, "Expected function name in declaration").Value
+	params, paramPos := P.ParseParams()
 
-	params := make([]string, 0)
-	for _, arg := range args {
-		if arg.GetKind() != IdentifierNode {
-			PrintAST(arg)
-			panic("Honk! Found something other than identifier in function declaration: %s")
+	P.eatExpected(lexer.OpenCurlyBracket, "Expected opening { before body of function declaration")
+	body := P.parseFunctionBody(P.parseBlockBody)
+	end := P.eatExpected(lexer.CloseCurlyBracket, "Expected closing } after body of function declaration").End
+
+	return FunctionDeclaration{Name: name, Body: body, Params: params, ParamPos: paramPos, Kind: FunctionDeclarationNode, Pos: pos, End: end}
+}
+
+// Runs parse for the body of a function, where return is allowed and break and continue
+// cannot reach a loop outside of the function
+func (P *Parser) parseFunctionBody(parse func() []Stmt) []Stmt {
+	loopDepth := P.loopDepth
+	P.functionDepth++
+	P.loopDepth = 0
+	body := parse()
+	P.functionDepth--
+	P.loopDepth = loopDepth
+
+	return body
+}
+
+// Parses anonymous functions like func (a, b) { ... }
+func (P *Parser) ParseFunctionExpr() Expr {
+	pos := P.eat().Pos // advance past func token
+	params, paramPos := P.ParseParams()
+
+	P.eatExpected(lexer.OpenCurlyBracket, "Expected opening { before body of function")
+	body := P.parseFunctionBody(P.parseBlockBody)
+	end := P.eatExpected(lexer.CloseCurlyBracket, "Expected closing } after body of function").End
+
+	return FunctionExpr{Kind: FunctionExprNode, Params: params, ParamPos: paramPos, Body: body, Pos: pos, End: end}
+}
+
+// Looks ahead from an open paren to check whether it starts the parameter list of an arrow function,
+// which is the case when the matching close paren is followed by =>
+func (P *Parser) isArrowFunction() bool {
+	depth := 0
+	for i, token := range P.tokens {
+		switch token.Type {
+		case lexer.OpenParen:
+			depth++
+		case lexer.CloseParen:
+			depth--
+			if depth == 0 {
+				return i+1 < len(P.tokens) && P.tokens[i+1].Type == lexer.Arrow
+			}
+		case lexer.EOF:
+			return false
 		}
+	}
+	return false
+}
 
-		params = append(params, arg.(Ident).Symbol)
+// Parses arrow functions: x => expr, (a, b) => expr and (a, b) => { ... }
+func (P *Parser) ParseArrowFunction() Expr {
+	pos := P.at().Pos
+	var params []string
+	var paramPos []lexer.Position
+
+	if P.at().Type == lexer.Identifier {
+		param := P.eat()
+		params, paramPos = []string{param.Value}, []lexer.Position{param.Pos}
+	} else {
+		params, paramPos = P.ParseParams()
 	}
+	P.eatExpected(lexer.Arrow, "Expected => after parameters of arrow function")
 
-	P.eatExpected(lexer.OpenCurlyBracket, "Honk! Expected opening { before body of function declaration")
-	body := make([]Stmt, 0)
+	var body []Stmt
+	if P.at().Type == lexer.OpenCurlyBracket {
+		P.eat()
+		body = P.parseFunctionBody(P.parseBlockBody)
+		P.eatExpected(lexer.CloseCurlyBracket, "Expected closing } after body of arrow function")
+	} else {
+		// The expression is the only statement, so it is what the call evaluates to
+		body = P.parseFunctionBody(func() []Stmt { return []Stmt{P.ParseExpr()} })
+	}
+
+	return FunctionExpr{Kind: FunctionExprNode, Params: params, ParamPos: paramPos, Body: body, Pos: pos, End: P.last.End}
+}
+
+func (P *Parser) ParseBranchStmt() Stmt {
+	pos := P.eat().Pos // move past if
+	P.eatExpected(lexer.OpenParen, "Expected opening ( before condition of if statement")
+	condition := P.ParseLogicalOrExpr() // We want to be able to allow things like if (x + 6 > 8 * 2)
+	P.eatExpected(lexer.CloseParen, "Expected closing ) following condition of if statement")
+	P.eatExpected(lexer.OpenCurlyBracket, "Expected opening { following if statement")
 
-	for P.at().Type != lexer.CloseCurlyBracket && P.at().Type != lexer.EOF && P.at().Type != lexer.Return {
-		body = append(body, P.ParseStatement())
+	body := P.parseBlockBody()
+	P.eatExpected(lexer.CloseCurlyBracket, "Expected } after body of if statement")
+
+	elseBody := make([]Stmt, 0)
+
+	switch P.at().Type {
+	case lexer.Elseif:
+		// elseif chains nest, the rest of the chain becomes the only statement of the else body.
+		// ParseBranchStmt eats the elseif the same way it eats an if
+		elseBody = append(elseBody, P.ParseBranchStmt())
+	case lexer.Else:
+		P.eat() // advance past else
+		P.eatExpected(lexer.OpenCurlyBracket, "Expected { or elseif after else")
+		elseBody = P.parseBlockBody()
+		P.eatExpected(lexer.CloseCurlyBracket, "Expected } after body of else")
 	}
 
-	P.eatExpected(lexer.CloseCurlyBracket, "Honk! Expected closing } after body of function declaration")
+	return BranchStmt{Kind: BranchNode, Condition: condition, Else: elseBody, Body: body, Pos: pos, End: P.last.End}
+}
+
+// Parses return statements, the value is optional so both return; and return x; are allowed
+func (P *Parser) ParseReturnStmt() Stmt {
+	keyword := P.eat() // advance past return
 
-	return FunctionDeclaration{Name: name, Body: body, Params: params, Kind: FunctionDeclarationNode}
+	if P.functionDepth == 0 {
+		P.reportAt(keyword, "Cannot return outside of a function")
+	}
+
+	var value *Expr
+	if P.at().Type != lexer.Semicolon && P.at().Type != lexer.CloseCurlyBracket {
+		val := P.ParseExpr()
+		value = &val
+	}
+
+	// Like expression statements, the semicolon is optional
+	if P.at().Type == lexer.Semicolon {
+		P.eat()
+	}
+
+	return ReturnStmt{Kind: ReturnNode, Value: value, Pos: keyword.Pos, End: P.last.End}
 }
 
-func (P *Parser) ParseBranchStmt() Stmt {
-	P.eat() // move past if
-	P.eatExpected(lexer.OpenParen, "Honk! Expected opening ( before condition of if statement")
-	condition := P.ParseChainedLogicalExpr() // We want to be able to allow things like if (x + 6 > 8 * 2)
-	P.eatExpected(lexer.CloseParen, "Honk!, Expected closing ) following condition of if statement")
-	P.eatExpected(lexer.OpenCurlyBracket, "Honk! Expected opening { following if statement")
+// parses the { body } of a loop
+func (P *Parser) parseLoopBody(loop string) []Stmt {
+	P.eatExpected(lexer.OpenCurlyBracket, fmt.Sprintf("Expected opening { before body of %s loop", loop))
+	P.loopDepth++
+	body := P.parseBlockBody()
+	P.loopDepth--
+	P.eatExpected(lexer.CloseCurlyBracket, fmt.Sprintf("Expected } after body of %s loop", loop))
 
-	body := make([]Stmt, 0)
+	return body
+}
+
+// Parses while (condition) { body }
+func (P *Parser) ParseWhileStmt() Stmt {
+	pos := P.eat().Pos // move past while
+	P.eatExpected(lexer.OpenParen, "Expected opening ( before condition of while loop")
+	condition := P.ParseLogicalOrExpr()
+	P.eatExpected(lexer.CloseParen, "Expected closing ) following condition of while loop")
+
+	body := P.parseLoopBody("while")
+
+	return WhileStmt{Kind: WhileNode, Condition: condition, Body: body, Pos: pos, End: P.last.End}
+}
 
-	for P.at().Type != lexer.CloseCurlyBracket {
-		body = append(body, P.ParseStatement())
+// Parses for (init; condition; update) { body }, where any part of the header can be left out
+func (P *Parser) ParseForStmt() Stmt {
+	pos := P.eat().Pos // move past for
+	P.eatExpected(lexer.OpenParen, "Expected opening ( after for")
+
+	var init *Stmt
+	switch P.at().Type {
+	case lexer.Semicolon:
+		P.eat()
+	case lexer.Mut, lexer.Const:
+		// Variable declarations eat their own semicolon
+		declaration := P.ParseVarDeclaration()
+		init = &declaration
+	default:
+		var expr Stmt = P.ParseExpr()
+		init = &expr
+		P.eatExpected(lexer.Semicolon, "Expected ; following initializer of for loop")
 	}
-	P.eatExpected(lexer.CloseCurlyBracket, "Honk! Expected } after body of if statement")
 
-	// TODO: Implement elseif?
-	elseBody := make([]Stmt, 0)
+	var condition *Expr
+	if P.at().Type != lexer.Semicolon {
+		expr := P.ParseLogicalOrExpr()
+		condition = &expr
+	}
+	P.eatExpected(lexer.Semicolon, "Expected ; following condition of for loop")
 
-	if P.at().Type == lexer.Else {
-		P.eat() // advance past else
-		P.eatExpected(lexer.OpenCurlyBracket, "Honk! Expected { after else")
-		for P.at().Type != lexer.CloseCurlyBracket {
-			elseBody = append(elseBody, P.ParseStatement())
-		}
-		P.eatExpected(lexer.CloseCurlyBracket, "Honk! Expected } after body of else")
+	var update *Expr
+	if P.at().Type != lexer.CloseParen {
+		expr := P.ParseExpr()
+		update = &expr
+	}
+	P.eatExpected(lexer.CloseParen, "Expected closing ) following for loop header")
+
+	body := P.parseLoopBody("for")
+
+	return ForStmt{Kind: ForNode, Init: init, Condition: condition, Update: update, Body: body, Pos: pos, End: P.last.End}
+}
+
+// Parses break and continue statements, the semicolon is optional
+func (P *Parser) ParseLoopControlStmt() Stmt {
+	keyword := P.eat()
+
+	if P.loopDepth == 0 {
+		P.reportAt(keyword, fmt.Sprintf("Cannot %s outside of a loop", keyword.Value))
 	}
 
-	return BranchStmt{Condition: condition, Else: elseBody, Body: body}
+	if P.at().Type == lexer.Semicolon {
+		P.eat()
+	}
+
+	if keyword.Type == lexer.Break {
+		return BreakStmt{Kind: BreakNode, Pos: keyword.Pos, End: P.last.End}
+	}
+	return ContinueStmt{Kind: ContinueNode, Pos: keyword.Pos, End: P.last.End}
 }
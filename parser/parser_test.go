@@ -0,0 +1,126 @@
+package parser
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+// Parses src and returns the types of the top level statements and the errors, in order
+func parse(t *testing.T, src string) ([]string, []string) {
+	t.Helper()
+	done := make(chan struct{})
+	var program Program
+	var parseErrors []ParseError
+	go func() {
+		defer close(done)
+		var p Parser
+		program, parseErrors = p.ProduceAST(src)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("parsing %q did not finish", src)
+	}
+
+	statements := []string{}
+	for _, stmt := range program.Body {
+		statements = append(statements, fmt.Sprintf("%T", stmt))
+	}
+	errors := []string{}
+	for _, err := range parseErrors {
+		errors = append(errors, err.Error())
+	}
+	return statements, errors
+}
+
+func sameStrings(t *testing.T, what string, src string, got []string, want []string) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Errorf("%q: got %s %q, want %q", src, what, got, want)
+		return
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("%q: %s %d is %q, want %q", src, what, i, got[i], want[i])
+		}
+	}
+}
+
+func TestErrorsAreReportedInSourceOrder(t *testing.T) {
+	src := "mut = 1;\nconst x;\nprint(1 +);\nreturn 2"
+	_, errors := parse(t, src)
+	sameStrings(t, "errors", src, errors, []string{
+		"Honk! 1:5: Expected variable name, found '='",
+		"Honk! 2:7: Constant variable x must be initialized",
+		"Honk! 3:10: Unexpected ')' found during parsing",
+		"Honk! 4:1: Cannot return outside of a function",
+	})
+}
+
+func TestRecovery(t *testing.T) {
+	for _, test := range []struct {
+		src        string
+		statements []string
+		errors     []string
+	}{
+		// At a semicolon
+		{
+			"mut x = ) + 2; print(x)",
+			[]string{"parser.InternalFunctionCallExpr"},
+			[]string{"Honk! 1:9: Unexpected ')' found during parsing"},
+		},
+		// At the brace closing a block
+		{
+			"func f() { mut = 1 } print(2)",
+			[]string{"parser.FunctionDeclaration", "parser.InternalFunctionCallExpr"},
+			[]string{"Honk! 1:16: Expected variable name, found '='"},
+		},
+		{
+			"if (true) { mut x = ; print(1) } print(2)",
+			[]string{"parser.BranchStmt", "parser.InternalFunctionCallExpr"},
+			[]string{"Honk! 1:21: Unexpected ';' found during parsing"},
+		},
+		// At a keyword starting the next statement
+		{
+			"mut x = 1 +\nmut y = 2;\nprint(y)",
+			[]string{"parser.VarDeclaration", "parser.InternalFunctionCallExpr"},
+			[]string{"Honk! 2:1: Unexpected 'mut' found during parsing"},
+		},
+		{
+			"print(1 +\nwhile (true) { break }",
+			[]string{"parser.WhileStmt"},
+			[]string{"Honk! 2:1: Unexpected 'while' found during parsing"},
+		},
+		// Stray closing braces at the top level are skipped one at a time
+		{
+			"}}}",
+			[]string{},
+			[]string{
+				"Honk! 1:1: Unexpected '}' found during parsing",
+				"Honk! 1:2: Unexpected '}' found during parsing",
+				"Honk! 1:3: Unexpected '}' found during parsing",
+			},
+		},
+	} {
+		statements, errors := parse(t, test.src)
+		sameStrings(t, "statements", test.src, statements, test.statements)
+		sameStrings(t, "errors", test.src, errors, test.errors)
+	}
+}
+
+func TestInputCutOffAtEOF(t *testing.T) {
+	for src, want := range map[string]string{
+		"func f( {":           "Honk! 1:9: Expected parameter name in function declaration, found '{'",
+		"if (x":               "Honk! 1:6: Expected closing ) following condition of if statement, found end of file",
+		"mut x = [1, 2":       "Honk! 1:14: Expected comma or closing bracket after array element, found end of file",
+		"{ a: ":               "Honk! 1:6: Unexpected end of file found during parsing",
+		"\"unterminated":      "Honk! 1:1: Unterminated string literal",
+		"f(1, ":               "Honk! 1:6: Unexpected end of file found during parsing",
+		"for (mut i = 0; i <": "Honk! 1:20: Unexpected end of file found during parsing",
+		"x = (":               "Honk! 1:6: Unexpected end of file found during parsing",
+	} {
+		_, errors := parse(t, src)
+		sameStrings(t, "errors", src, errors, []string{want})
+	}
+}
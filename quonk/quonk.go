@@ -0,0 +1,182 @@
+// Package quonk embeds the QuonkScript interpreter in Go programs
+package quonk
+
+import (
+	"QuonkScript/compiler"
+	"QuonkScript/parser"
+	"QuonkScript/resolver"
+	"QuonkScript/runtime"
+	"QuonkScript/vm"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Options configures an Interpreter, the zero value is ready to use
+type Options struct {
+	Stdout io.Writer // where print writes to, defaults to os.Stdout
+	// Applied to each run and call separately. By default only the call depth is limited, to runtime.DefaultMaxCallDepth,
+	// so that deep recursion fails with runtime.ErrStackOverflow. Set Limits.MaxCallDepth to raise it, or to -1 to remove it.
+	// The tree-walker uses about 10 KB of Go stack per call, more for calls inside of deeply nested expressions, and Go
+	// stops the whole process once a goroutine's stack reaches 1 GB (see runtime/debug.SetMaxStack). So without the VM,
+	// a MaxCallDepth much above 50000 can crash instead of failing with ErrStackOverflow. The VM keeps calls between
+	// QuonkScript functions off the Go stack, only calls made back into QuonkScript by natives use it
+	Limits runtime.Limits
+	VM     bool // compile scripts to bytecode and run them on the vm instead of evaluating the AST
+}
+
+// Interpreter runs QuonkScript source against a set of globals that persist between runs,
+// so a file can declare functions that are called later with Call.
+// An Interpreter is not safe for concurrent use
+type Interpreter struct {
+	parser parser.Parser
+	scope  *runtime.Scope
+	vm     bool
+}
+
+// ParseErrors holds every syntax error found in a script, it is returned instead of running the script
+type ParseErrors []parser.ParseError
+
+func (e ParseErrors) Error() string {
+	return joinErrors(e)
+}
+
+// ResolveErrors holds every mistake with variables found in a script, such as using an undeclared variable.
+// Like ParseErrors, it is returned instead of running the script
+type ResolveErrors []resolver.ResolveError
+
+func (e ResolveErrors) Error() string {
+	return joinErrors(e)
+}
+
+// One error per line
+func joinErrors[E error](errs []E) string {
+	messages := make([]string, 0, len(errs))
+	for _, err := range errs {
+		messages = append(messages, err.Error())
+	}
+	return strings.Join(messages, "\n")
+}
+
+func New(options Options) *Interpreter {
+	scope := runtime.NewScope(nil)
+	scope.State().Stdout = options.Stdout
+	scope.State().Limits = options.Limits
+	runtime.SetupScope(scope)
+
+	return &Interpreter{scope: scope, vm: options.VM}
+}
+
+// Parses and runs src, returning the value of the last statement. Syntax errors are returned as ParseErrors, mistakes
+// with variables as ResolveErrors, errors found while compiling for the vm as a compiler.CompileError
+// and errors raised while running as a *runtime.RuntimeError
+func (i *Interpreter) RunString(src string) (runtime.RuntimeValue, error) {
+	return i.RunStringContext(context.Background(), src)
+}
+
+// Like RunString, but the program is stopped with an error wrapping runtime.ErrCancelled once ctx is done
+func (i *Interpreter) RunStringContext(ctx context.Context, src string) (runtime.RuntimeValue, error) {
+	if i.vm {
+		function, err := i.Compile(src)
+		if err != nil {
+			return runtime.MakeNull(), err
+		}
+		return vm.RunContext(ctx, function, i.scope)
+	}
+
+	program, err := i.parse(src)
+	if err != nil {
+		return runtime.MakeNull(), err
+	}
+	return runtime.RunContext(ctx, program, i.scope)
+}
+
+// Parses and resolves src against the current globals, then compiles it to bytecode without running it
+func (i *Interpreter) Compile(src string) (*compiler.Function, error) {
+	program, err := i.parse(src)
+	if err != nil {
+		return nil, err
+	}
+	return compiler.Compile(program)
+}
+
+func (i *Interpreter) parse(src string) (parser.Program, error) {
+	program, errs := i.parser.ProduceAST(src)
+	if len(errs) > 0 {
+		return program, ParseErrors(errs)
+	}
+
+	globals := make(resolver.Globals, len(i.scope.Variables))
+	for name, variable := range i.scope.Variables {
+		globals[name] = variable.IsConstant()
+	}
+	program, resolveErrs := resolver.Resolve(program, globals)
+	if len(resolveErrs) > 0 {
+		return program, ResolveErrors(resolveErrs)
+	}
+	return program, nil
+}
+
+// Reads and runs a script the same way as RunString
+func (i *Interpreter) RunFile(filename string) (runtime.RuntimeValue, error) {
+	return i.RunFileContext(context.Background(), filename)
+}
+
+func (i *Interpreter) RunFileContext(ctx context.Context, filename string) (runtime.RuntimeValue, error) {
+	bytes, err := os.ReadFile(filename)
+	if err != nil {
+		return runtime.MakeNull(), fmt.Errorf("Honk! Cannot read file %s: %w", filename, err)
+	}
+
+	return i.RunStringContext(ctx, string(bytes))
+}
+
+// Declares a mutable global variable, or assigns to it if it already exists.
+// value can be a runtime value or any Go value that runtime.ToValue can convert, including funcs.
+// Scripts are resolved before they run, so globals they use must be set before running them
+func (i *Interpreter) SetGlobal(name string, value any) error {
+	converted, err := runtime.ToValue(value)
+	if err != nil {
+		return err
+	}
+
+	if _, declared := i.scope.Variables[name]; declared {
+		_, err := i.scope.AssignVariable(name, converted)
+		return err
+	}
+
+	_, err = i.scope.DeclareVariable(name, converted, false)
+	return err
+}
+
+func (i *Interpreter) GetGlobal(name string) (runtime.RuntimeValue, error) {
+	if _, declared := i.scope.Variables[name]; !declared {
+		return runtime.MakeNull(), fmt.Errorf("Honk! No global variable %s", name)
+	}
+
+	return i.scope.LookupVariable(name)
+}
+
+// Calls the global function fnName with args and returns its result. Like SetGlobal, args are converted with runtime.ToValue
+func (i *Interpreter) Call(fnName string, args ...any) (runtime.RuntimeValue, error) {
+	return i.CallContext(context.Background(), fnName, args...)
+}
+
+// Like Call, but stopped once ctx is done the same way as RunStringContext
+func (i *Interpreter) CallContext(ctx context.Context, fnName string, args ...any) (runtime.RuntimeValue, error) {
+	fn, err := i.GetGlobal(fnName)
+	if err != nil {
+		return runtime.MakeNull(), err
+	}
+
+	values := make([]runtime.RuntimeValue, len(args))
+	for index, arg := range args {
+		if values[index], err = runtime.ToValue(arg); err != nil {
+			return runtime.MakeNull(), err
+		}
+	}
+
+	return runtime.CallContext(ctx, fn, values, i.scope)
+}
@@ -0,0 +1,160 @@
+package quonk
+
+import (
+	"bytes"
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata with what the tree-walker prints")
+
+// The benchmark scripts and the scripts in testdata
+func scripts(tb testing.TB) []string {
+	files := []string{}
+	for _, pattern := range []string{"../benchmarks/*.qs", "testdata/*.qs"} {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			tb.Fatal(err)
+		}
+		files = append(files, matches...)
+	}
+	if len(files) == 0 {
+		tb.Fatal("no scripts found")
+	}
+	return files
+}
+
+func runScript(tb testing.TB, file string, vm bool, stdout io.Writer) {
+	src, err := os.ReadFile(file)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	if _, err := New(Options{Stdout: stdout, VM: vm}).RunString(string(src)); err != nil {
+		tb.Fatalf("%s: %v", file, err)
+	}
+}
+
+// Runs src on both engines and checks that each prints want
+func expectOutput(t *testing.T, src string, want string) {
+	t.Helper()
+	for _, engine := range []struct {
+		name string
+		vm   bool
+	}{{"tree-walker", false}, {"vm", true}} {
+		var out bytes.Buffer
+		interpreter := New(Options{Stdout: &out, VM: engine.vm})
+		if _, err := interpreter.RunString(src); err != nil {
+			t.Errorf("%s: %v", engine.name, err)
+			continue
+		}
+		if got := out.String(); got != want {
+			t.Errorf("%s printed %q, want %q", engine.name, got, want)
+		}
+	}
+}
+
+func TestPrintArrayContainingItself(t *testing.T) {
+	expectOutput(t, "mut a = [1, 2];\npush(a, a);\nprint(a);\nprint([a, a])", "[1, 2, [...]] \n[[1, 2, [...]], [1, 2, [...]]] \n")
+}
+
+func TestPrintObjectContainingItself(t *testing.T) {
+	expectOutput(t, "const o = {a: 1};\no.self = o;\nprint(o);\nprint(o.self.self.a)", "{\"a\": 1, \"self\": {...}} \n1 \n")
+}
+
+func TestConcatenateValueContainingItself(t *testing.T) {
+	expectOutput(t, "const o = {};\no.self = o;\nmut a = [];\npush(a, a);\nprint(\"o: \" + o);\nprint(a + \"!\")", "o: {\"self\": {...}} \n[[...]]! \n")
+}
+
+// Every script prints the same on both engines, and the same as its golden file
+func TestScriptsGolden(t *testing.T) {
+	for _, file := range scripts(t) {
+		name := strings.TrimSuffix(filepath.Base(file), ".qs")
+		t.Run(name, func(t *testing.T) {
+			var treeWalker, vm bytes.Buffer
+			runScript(t, file, false, &treeWalker)
+			runScript(t, file, true, &vm)
+
+			golden := filepath.Join("testdata", name+".golden")
+			if *update {
+				if err := os.WriteFile(golden, treeWalker.Bytes(), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatalf("%v, run go test ./quonk -update to create it", err)
+			}
+
+			if treeWalker.String() != string(want) {
+				t.Errorf("tree-walker printed %q, want %q", treeWalker.String(), want)
+			}
+			if vm.String() != string(want) {
+				t.Errorf("vm printed %q, want %q", vm.String(), want)
+			}
+		})
+	}
+}
+
+func benchmarkScripts(b *testing.B, vm bool) {
+	for _, file := range scripts(b) {
+		b.Run(strings.TrimSuffix(filepath.Base(file), ".qs"), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				runScript(b, file, vm, io.Discard)
+			}
+		})
+	}
+}
+
+func BenchmarkTreeWalker(b *testing.B) {
+	benchmarkScripts(b, false)
+}
+
+func BenchmarkVM(b *testing.B) {
+	benchmarkScripts(b, true)
+}
+
+func TestPrintWholeFloats(t *testing.T) {
+	expectOutput(t, "print(2.0, float(2), 2, 1e3, 2.5, 10 / 4.0, 1e21, [1.0, 1]);\nprint(\"x\" + 3.0)", "2.0 2.0 2 1000.0 2.5 2.5 1e+21 [1.0, 1] \nx3.0 \n")
+}
+
+// Runs src on both engines and checks that each fails with want
+func expectError(t *testing.T, src string, want string) {
+	t.Helper()
+	for _, vm := range []bool{false, true} {
+		_, err := New(Options{Stdout: io.Discard, VM: vm}).RunString(src)
+		if err == nil || err.Error() != want {
+			t.Errorf("vm %t: got error %v, want %s", vm, err, want)
+		}
+	}
+}
+
+func TestCallbackErrorsAreLocatedAtTheNative(t *testing.T) {
+	expectError(t, "mut a = [1, 2];\nforEach(a, (x, i, extra) => x)", "Honk! 2:1: Function <anonymous> expects 3 arguments but was called with 2")
+	expectError(t, "func deep(n) {\n  forEach([n], (x) => 1 + deep(x + 1))\n  0\n}\ndeep(0)", "Honk! 2:3: Maximum call depth exceeded: more than 10000 nested calls while calling <anonymous>")
+}
+
+func TestForLoopClosuresKeepTheirIteration(t *testing.T) {
+	src := `mut fs = [];
+for (mut i = 0; i < 3; i = i + 1) { push(fs, () => i) }
+print(map(fs, (f) => f()));
+func inner() {
+  mut gs = [];
+  for (mut i = 0; i < 6; i = i + 1) {
+    if (i % 2 == 0) { continue }
+    i = i + 1;
+    push(gs, () => i)
+  }
+  map(gs, (g) => g())
+}
+print(inner())`
+	expectOutput(t, src, "[0, 1, 2] \n[2, 4, 6] \n")
+}
+
+func TestCompareIntegersWithFloatsExactly(t *testing.T) {
+	src := "const big = 9007199254740993;\nconst rounded = 9007199254740992.0;\nprint(big == rounded, big != rounded, big > rounded, rounded < big, rounded >= big);\nprint(1 == 1.0, 2 < 2.5, -3 > -3.5, 9223372036854775807 < 9223372036854775808.0)"
+	expectOutput(t, src, "false true true true false \ntrue true true true \n")
+}
@@ -0,0 +1,325 @@
+// Package resolver binds the variables of a parsed program to the scopes they are declared in, so that the runtime
+// and the vm can find local variables by index instead of by name. Mistakes that can be seen without running the
+// program, such as using a variable that is never declared, are reported here
+package resolver
+
+import (
+	"QuonkScript/lexer"
+	"QuonkScript/parser"
+	"fmt"
+	"sort"
+)
+
+// ResolveError is a mistake in the use of a variable found before the program runs
+type ResolveError struct {
+	Message string
+	Pos     lexer.Position
+}
+
+func (e ResolveError) Error() string {
+	return fmt.Sprintf("Honk! %s: %s", e.Pos, e.Message)
+}
+
+// Globals are the variables already declared in the scope a program will run in, true for constants
+type Globals map[string]bool
+
+type variable struct {
+	slot     int
+	constant bool
+	declared bool // whether resolving has got past the declaration
+}
+
+// Mirrors a runtime.Scope that will be created while the program runs
+type scope struct {
+	variables map[string]*variable
+	slots     int
+	function  int  // how deeply nested in functions the scope is, 0 for the program
+	global    bool // variables of the scope the program runs in are looked up by name
+}
+
+type resolver struct {
+	scopes   []*scope
+	function int
+	errors   []ResolveError
+}
+
+// Resolves the variables of program, which will run in a scope with globals already declared.
+// Globals stay bound by name since they can be declared by earlier programs or from Go, every other variable is
+// bound to a slot of the scope it is declared in. The statements of program are updated in place.
+// The program is only safe to run if there are no errors
+func Resolve(program parser.Program, globals Globals) (parser.Program, []ResolveError) {
+	r := &resolver{errors: make([]ResolveError, 0)}
+
+	global := &scope{variables: make(map[string]*variable), global: true}
+	for name, constant := range globals {
+		global.variables[name] = &variable{constant: constant, declared: true}
+	}
+	r.scopes = append(r.scopes, global)
+
+	r.declareAll(program.Body)
+	r.resolveBody(program.Body)
+
+	// Redeclarations are found before everything else, report the errors in the order they appear in
+	sort.SliceStable(r.errors, func(i, j int) bool { return r.errors[i].Pos.Offset < r.errors[j].Pos.Offset })
+	return program, r.errors
+}
+
+func (r *resolver) fail(pos lexer.Position, format string, args ...any) {
+	r.errors = append(r.errors, ResolveError{Message: fmt.Sprintf(format, args...), Pos: pos})
+}
+
+// Scopes
+
+func (r *resolver) current() *scope {
+	return r.scopes[len(r.scopes)-1]
+}
+
+func (r *resolver) beginScope() {
+	r.scopes = append(r.scopes, &scope{variables: make(map[string]*variable), function: r.function})
+}
+
+func (r *resolver) endScope() *scope {
+	s := r.current()
+	r.scopes = r.scopes[:len(r.scopes)-1]
+	return s
+}
+
+// Declares every variable of body up front, so that functions can use variables declared after them
+func (r *resolver) declareAll(body []parser.Stmt) {
+	for _, stmt := range body {
+		switch stmt := stmt.(type) {
+		case parser.VarDeclaration:
+			r.declare(stmt.Identifier, stmt.Constant, stmt.Pos)
+		case parser.FunctionDeclaration:
+			r.declare(stmt.Name, true, stmt.Pos)
+		}
+	}
+}
+
+func (r *resolver) declare(name string, constant bool, pos lexer.Position) {
+	s := r.current()
+	if _, ok := s.variables[name]; ok {
+		r.fail(pos, "Cannot redeclare variable %s", name)
+		return
+	}
+	s.variables[name] = &variable{slot: s.slots, constant: constant}
+	s.slots++
+}
+
+// Marks the declaration of name as reached and returns its binding
+func (r *resolver) define(name string) parser.Binding {
+	s := r.current()
+	v := s.variables[name]
+	v.declared = true
+	if s.global {
+		return parser.Binding{}
+	}
+	return parser.Binding{Local: true, Slot: v.slot}
+}
+
+// Finds the variable name refers to. Reading a variable before its declaration is an error unless it happens
+// in a function, which might only be called once the variable is declared
+func (r *resolver) lookup(name string, pos lexer.Position) (parser.Binding, *variable) {
+	for i := len(r.scopes) - 1; i >= 0; i-- {
+		s := r.scopes[i]
+		v, ok := s.variables[name]
+		if !ok {
+			continue
+		}
+
+		if !v.declared && s.function == r.function {
+			r.fail(pos, "Cannot use variable %s before it is declared", name)
+		}
+		if s.global {
+			return parser.Binding{}, v
+		}
+		return parser.Binding{Local: true, Depth: len(r.scopes) - 1 - i, Slot: v.slot}, v
+	}
+
+	r.fail(pos, "Cannot resolve variable %s", name)
+	return parser.Binding{}, nil
+}
+
+// Statements
+
+func (r *resolver) resolveBody(body []parser.Stmt) {
+	for i, stmt := range body {
+		body[i] = r.resolveStmt(stmt)
+	}
+}
+
+// Resolves body in a scope of its own, like the bodies of branches and loops
+func (r *resolver) resolveBlock(body []parser.Stmt) {
+	r.beginScope()
+	r.declareAll(body)
+	r.resolveBody(body)
+	r.endScope()
+}
+
+func (r *resolver) resolveStmt(stmt parser.Stmt) parser.Stmt {
+	switch stmt := stmt.(type) {
+	case parser.VarDeclaration:
+		if stmt.Value != nil {
+			value := r.resolveExpr(*stmt.Value)
+			stmt.Value = &value
+		}
+		stmt.Binding = r.define(stmt.Identifier)
+		return stmt
+	case parser.FunctionDeclaration:
+		stmt.Binding = r.define(stmt.Name)
+		stmt.Slots = r.resolveFunction(stmt.Params, stmt.ParamPos, stmt.Body, stmt.Pos)
+		return stmt
+	case parser.BranchStmt:
+		stmt.Condition = r.resolveExpr(stmt.Condition)
+		r.resolveBlock(stmt.Body)
+		r.resolveBlock(stmt.Else)
+		return stmt
+	case parser.ReturnStmt:
+		if stmt.Value != nil {
+			value := r.resolveExpr(*stmt.Value)
+			stmt.Value = &value
+		}
+		return stmt
+	case parser.WhileStmt:
+		stmt.Condition = r.resolveExpr(stmt.Condition)
+		r.resolveBlock(stmt.Body)
+		return stmt
+	case parser.ForStmt:
+		return r.resolveFor(stmt)
+	case parser.BreakStmt, parser.ContinueStmt:
+		return stmt
+	case parser.Expr:
+		return r.resolveExpr(stmt)
+	}
+
+	r.fail(stmt.GetPos(), "Cannot resolve node of kind %d", stmt.GetKind())
+	return stmt
+}
+
+func (r *resolver) resolveFor(stmt parser.ForStmt) parser.Stmt {
+	// Mirrors the scope evalForStmt creates for the header. Its copies for each iteration have the same slots
+	r.beginScope()
+	if stmt.Init != nil {
+		r.declareAll([]parser.Stmt{*stmt.Init})
+		init := r.resolveStmt(*stmt.Init)
+		stmt.Init = &init
+	}
+	if stmt.Condition != nil {
+		condition := r.resolveExpr(*stmt.Condition)
+		stmt.Condition = &condition
+	}
+	if stmt.Update != nil {
+		update := r.resolveExpr(*stmt.Update)
+		stmt.Update = &update
+	}
+	r.resolveBlock(stmt.Body)
+	r.endScope()
+
+	return stmt
+}
+
+// Resolves a function body in the scope of a call, which holds the parameters followed by the variables declared
+// in the body. Returns the number of variables in that scope. A repeated parameter is reported where it is named,
+// or at the function if the AST was built without paramPos
+func (r *resolver) resolveFunction(params []string, paramPos []lexer.Position, body []parser.Stmt, pos lexer.Position) int {
+	r.function++
+	r.beginScope()
+
+	for i, param := range params {
+		at := pos
+		if i < len(paramPos) {
+			at = paramPos[i]
+		}
+		r.declare(param, false, at)
+		r.define(param)
+	}
+	r.declareAll(body)
+	r.resolveBody(body)
+
+	s := r.endScope()
+	r.function--
+	return s.slots
+}
+
+// Expressions
+
+func (r *resolver) resolveExpr(expr parser.Expr) parser.Expr {
+	switch expr := expr.(type) {
+	case parser.Ident:
+		expr.Binding, _ = r.lookup(expr.Symbol, expr.Pos)
+		return expr
+	case parser.BinaryExpr:
+		expr.Left = r.resolveExpr(expr.Left)
+		expr.Right = r.resolveExpr(expr.Right)
+		return expr
+	case parser.ComparisonExpr:
+		expr.Left = r.resolveExpr(expr.Left)
+		expr.Right = r.resolveExpr(expr.Right)
+		return expr
+	case parser.LogicalExpr:
+		expr.Left = r.resolveExpr(expr.Left)
+		expr.Right = r.resolveExpr(expr.Right)
+		return expr
+	case parser.UnaryExpr:
+		expr.Operand = r.resolveExpr(expr.Operand)
+		return expr
+	case parser.VarAssignmentExpr:
+		return r.resolveAssignment(expr)
+	case parser.ObjectLiteral:
+		properties := make([]parser.PropertyLiteral, len(expr.Properties))
+		for i, property := range expr.Properties {
+			if property.Value == nil {
+				// { key } reads the variable key
+				property.Binding, _ = r.lookup(property.Key, property.Pos)
+			} else {
+				value := r.resolveExpr(*property.Value)
+				property.Value = &value
+			}
+			properties[i] = property
+		}
+		expr.Properties = properties
+		return expr
+	case parser.ArrayLiteral:
+		for i, element := range expr.Elements {
+			expr.Elements[i] = r.resolveExpr(element)
+		}
+		return expr
+	case parser.MemberExpr:
+		expr.Object = r.resolveExpr(expr.Object)
+		// The field of obj.field is a name, not a variable
+		if expr.Computed {
+			expr.Field = r.resolveExpr(expr.Field)
+		}
+		return expr
+	case parser.InternalFunctionCallExpr:
+		for i, arg := range expr.Args {
+			expr.Args[i] = r.resolveExpr(arg)
+		}
+		expr.Caller = r.resolveExpr(expr.Caller)
+		return expr
+	case parser.FunctionExpr:
+		expr.Slots = r.resolveFunction(expr.Params, expr.ParamPos, expr.Body, expr.Pos)
+		return expr
+	}
+	// Literals do not use variables
+	return expr
+}
+
+func (r *resolver) resolveAssignment(expr parser.VarAssignmentExpr) parser.Expr {
+	expr.Value = r.resolveExpr(expr.Value)
+
+	ident, ok := expr.Assignee.(parser.Ident)
+	if !ok {
+		// Members are resolved like any other expression, anything else is an error once it is evaluated
+		expr.Assignee = r.resolveExpr(expr.Assignee)
+		return expr
+	}
+
+	binding, v := r.lookup(ident.Symbol, ident.Pos)
+	if v != nil && v.constant {
+		r.fail(ident.Pos, "Cannot assign to constant variable %s", ident.Symbol)
+	}
+	ident.Binding = binding
+	expr.Assignee = ident
+	return expr
+}
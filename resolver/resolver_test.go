@@ -0,0 +1,143 @@
+package resolver
+
+import (
+	"QuonkScript/parser"
+	"fmt"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+var globals = Globals{"print": true, "push": true, "true": true}
+
+func resolve(t *testing.T, src string) (parser.Program, []string) {
+	t.Helper()
+	var p parser.Parser
+	program, parseErrors := p.ProduceAST(src)
+	if len(parseErrors) > 0 {
+		t.Fatalf("%q: %v", src, parseErrors)
+	}
+
+	program, resolveErrors := Resolve(program, globals)
+	errors := []string{}
+	for _, err := range resolveErrors {
+		errors = append(errors, err.Error())
+	}
+	return program, errors
+}
+
+func expectErrors(t *testing.T, src string, want ...string) {
+	t.Helper()
+	_, got := resolve(t, src)
+	if len(got) != len(want) {
+		t.Errorf("%q: got errors %q, want %q", src, got, want)
+		return
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("%q: error %d is %q, want %q", src, i, got[i], want[i])
+		}
+	}
+}
+
+// Every identifier of node in source order, as name for globals or name@depth:slot for locals
+func bindings(node any) []string {
+	idents := []parser.Ident{}
+	var walk func(v reflect.Value)
+	walk = func(v reflect.Value) {
+		switch v.Kind() {
+		case reflect.Interface, reflect.Pointer:
+			if !v.IsNil() {
+				walk(v.Elem())
+			}
+		case reflect.Slice:
+			for i := 0; i < v.Len(); i++ {
+				walk(v.Index(i))
+			}
+		case reflect.Struct:
+			if ident, ok := v.Interface().(parser.Ident); ok {
+				idents = append(idents, ident)
+				return
+			}
+			for i := 0; i < v.NumField(); i++ {
+				walk(v.Field(i))
+			}
+		}
+	}
+	walk(reflect.ValueOf(node))
+
+	sort.Slice(idents, func(i, j int) bool { return idents[i].Pos.Offset < idents[j].Pos.Offset })
+	found := []string{}
+	for _, ident := range idents {
+		if ident.Binding.Local {
+			found = append(found, fmt.Sprintf("%s@%d:%d", ident.Symbol, ident.Binding.Depth, ident.Binding.Slot))
+		} else {
+			found = append(found, ident.Symbol)
+		}
+	}
+	return found
+}
+
+func expectBindings(t *testing.T, src string, want ...string) {
+	t.Helper()
+	program, errors := resolve(t, src)
+	if len(errors) > 0 {
+		t.Fatalf("%q: %q", src, errors)
+	}
+	got := bindings(program)
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("%q: got bindings %q, want %q", src, got, want)
+	}
+}
+
+func TestUndeclaredVariable(t *testing.T) {
+	expectErrors(t, "print(x)", "Honk! 1:7: Cannot resolve variable x")
+	expectErrors(t, "func f() {\n  y = 1\n}", "Honk! 2:3: Cannot resolve variable y")
+	// A variable of a block is gone after it
+	expectErrors(t, "if (true) { mut z = 1; }\nprint(z)", "Honk! 2:7: Cannot resolve variable z")
+}
+
+func TestRedeclaration(t *testing.T) {
+	expectErrors(t, "mut x = 1;\nmut x = 2;", "Honk! 2:1: Cannot redeclare variable x")
+	expectErrors(t, "mut f = 1;\nfunc f() {}", "Honk! 2:1: Cannot redeclare variable f")
+	expectErrors(t, "func f(a, b, a) {}", "Honk! 1:14: Cannot redeclare variable a")
+	expectErrors(t, "const g = (x, x) => x;", "Honk! 1:15: Cannot redeclare variable x")
+	// Shadowing a variable of an enclosing scope is allowed
+	expectErrors(t, "mut x = 1;\nif (true) { mut x = 2; }\nfunc h(x) { x }")
+}
+
+func TestUseBeforeDeclaration(t *testing.T) {
+	expectErrors(t, "print(x);\nmut x = 1;", "Honk! 1:7: Cannot use variable x before it is declared")
+	expectErrors(t, "func f() {\n  print(y);\n  mut y = 1;\n}", "Honk! 2:9: Cannot use variable y before it is declared")
+	expectErrors(t, "mut z = z + 1;", "Honk! 1:9: Cannot use variable z before it is declared")
+	// A function may use variables declared after it, as it might only be called once they are
+	expectErrors(t, "func f() { later }\nmut later = 1;")
+	expectErrors(t, "func f() {\n  func g() { inner }\n  mut inner = 1;\n}")
+}
+
+func TestAssignToConstant(t *testing.T) {
+	expectErrors(t, "const x = 1;\nx = 2", "Honk! 2:1: Cannot assign to constant variable x")
+	expectErrors(t, "func f() {}\nf = 1", "Honk! 2:1: Cannot assign to constant variable f")
+	expectErrors(t, "print = 1", "Honk! 1:1: Cannot assign to constant variable print")
+	expectErrors(t, "const c = 1;\nfunc f() {\n  c = 2\n}", "Honk! 3:3: Cannot assign to constant variable c")
+}
+
+func TestBindings(t *testing.T) {
+	// Variables of the program are globals, found by name
+	expectBindings(t, "mut x = 1;\nprint(x)", "print", "x")
+
+	// Parameters take the first slots of a call, then the variables of the body
+	expectBindings(t, "func f(a, b) {\n  mut c = a;\n  b + c\n}", "a@0:0", "b@0:1", "c@0:2")
+
+	// Each block is a scope of its own
+	expectBindings(t, "func f(a) {\n  if (a) {\n    mut b = a;\n    b\n  }\n}", "a@0:0", "a@1:0", "b@0:0")
+
+	// The header of a for loop is a scope around the body
+	expectBindings(t, "func f(n) {\n  for (mut i = 0; i < n; i = i + 1) {\n    mut j = i;\n    print(j)\n  }\n}",
+		"i@0:0", "n@1:0", "i@0:0", "i@0:0", "i@1:0", "print", "j@0:0")
+
+	// Closures reach the variables of the functions they are declared in
+	expectBindings(t, "func counter() {\n  mut count = 0;\n  () => {\n    count = count + 1;\n    count\n  }\n}",
+		"count@1:0", "count@1:0", "count@1:0")
+	expectBindings(t, "func outer(a) {\n  func inner(b) {\n    (c) => a + b + c\n  }\n}", "a@2:0", "b@1:0", "c@0:0")
+}
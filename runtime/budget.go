@@ -0,0 +1,122 @@
+package runtime
+
+import (
+	"QuonkScript/parser"
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Errors wrapped by the RuntimeError returned when a program is stopped, check for them with errors.Is.
+// Going over the call depth is one way of exceeding the budget, so ErrStackOverflow also matches ErrBudgetExceeded
+var (
+	ErrBudgetExceeded       = errors.New("Execution budget exceeded")
+	ErrCancelled            = errors.New("Execution cancelled")
+	ErrStackOverflow  error = budgetExceeded{"Maximum call depth exceeded"}
+)
+
+// A particular way of exceeding the budget, which errors.Is matches against ErrBudgetExceeded
+type budgetExceeded struct {
+	message string
+}
+
+func (e budgetExceeded) Error() string {
+	return e.message
+}
+
+func (e budgetExceeded) Is(target error) bool {
+	return target == ErrBudgetExceeded
+}
+
+// Call depth used when Limits.MaxCallDepth is 0. Every call nests a handful of Go calls inside of each other,
+// so without a limit deep recursion crashes the whole process once the Go stack runs out
+const DefaultMaxCallDepth = 10000
+
+// Limits on the work a single Run or Call may do, so that untrusted programs cannot run forever.
+// The zero value only limits the call depth, to DefaultMaxCallDepth
+type Limits struct {
+	MaxSteps     int           // maximum number of nodes evaluated, 0 for no limit
+	MaxCallDepth int           // maximum number of nested function calls, 0 for DefaultMaxCallDepth and negative for no limit
+	Timeout      time.Duration // maximum wall clock time, 0 for no limit
+}
+
+// Budget of the run in progress, only the outermost Run or Call starts a new one
+type budget struct {
+	runs   int             // nesting of Run and Call, natives calling back into QuonkScript share the budget of their caller
+	steps  int             // nodes evaluated so far
+	ctx    context.Context // the context the run was started with
+	done   <-chan struct{} // closed when the run is cancelled or times out, nil if it never is
+	cancel context.CancelFunc
+}
+
+// Starts a budget for a run if one is not in progress already, the returned func must be called once the run is over.
+// Run and Call do this themselves, it is exported for the vm package
+func (s *State) StartBudget(ctx context.Context) func() {
+	b := &s.budget
+	b.runs++
+	if b.runs > 1 {
+		return func() { b.runs-- }
+	}
+
+	b.steps = 0
+	b.ctx = ctx
+	runCtx, cancel := ctx, context.CancelFunc(func() {})
+	if s.Limits.Timeout > 0 {
+		runCtx, cancel = context.WithTimeout(ctx, s.Limits.Timeout)
+	}
+	b.done, b.cancel = runCtx.Done(), cancel
+
+	return func() {
+		b.runs--
+		b.cancel()
+		b.ctx, b.done, b.cancel = nil, nil, nil
+	}
+}
+
+// Counts one step against the budget, returning an error once the budget runs out.
+// Evaluate counts every node, the vm counts every instruction
+func (s *State) Step() error {
+	s.budget.steps++
+	if s.Limits.MaxSteps > 0 && s.budget.steps > s.Limits.MaxSteps {
+		return fmt.Errorf("%w: more than %d steps evaluated", ErrBudgetExceeded, s.Limits.MaxSteps)
+	}
+
+	if s.budget.done == nil {
+		return nil
+	}
+	return s.checkDone()
+}
+
+func (s *State) checkDone() error {
+	b := &s.budget
+	select {
+	case <-b.done:
+		if err := b.ctx.Err(); err != nil {
+			return fmt.Errorf("%w: %w", ErrCancelled, err)
+		}
+		// The context passed in is still live, so it was the Timeout that ran out
+		return fmt.Errorf("%w: timeout of %s exceeded", ErrBudgetExceeded, s.Limits.Timeout)
+	default:
+		return nil
+	}
+}
+
+// Returns ErrStackOverflow if calling function would go over the maximum call depth
+func (s *State) CheckCallDepth(function string) error {
+	max := s.Limits.MaxCallDepth
+	if max == 0 {
+		max = DefaultMaxCallDepth
+	}
+	if max > 0 && len(s.Frames) >= max {
+		return fmt.Errorf("%w: more than %d nested calls while calling %s", ErrStackOverflow, max, function)
+	}
+	return nil
+}
+
+// Counts the evaluation of node against the budget, raising at node once the budget runs out
+func (s *State) step(scope *Scope, node parser.Node) {
+	if err := s.Step(); err != nil {
+		raiseError(scope, node, err)
+	}
+}
@@ -0,0 +1,97 @@
+package runtime_test
+
+import (
+	"QuonkScript/quonk"
+	"QuonkScript/runtime"
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+)
+
+const (
+	infiniteLoop = "mut n = 0;\nwhile (true) { n = n + 1 }"
+	deepCall     = "func deep(n) { 1 + deep(n + 1) }\ndeep(0)"
+)
+
+// Runs src on both engines with limits and ctx, and checks that each fails with want. Where a program is stopped
+// depends on timing and on how each engine counts steps, so the position is not compared
+func expectStopped(t *testing.T, ctx context.Context, limits runtime.Limits, src string, want string, is []error, isNot []error) {
+	t.Helper()
+	for _, vm := range []bool{false, true} {
+		_, err := quonk.New(quonk.Options{Stdout: io.Discard, VM: vm, Limits: limits}).RunStringContext(ctx, src)
+		var runtimeErr *runtime.RuntimeError
+		if !errors.As(err, &runtimeErr) || runtimeErr.Message != want {
+			t.Errorf("vm %t: got error %v, want %s", vm, err, want)
+		}
+		for _, target := range is {
+			if !errors.Is(err, target) {
+				t.Errorf("vm %t: %v is not %v", vm, err, target)
+			}
+		}
+		for _, target := range isNot {
+			if errors.Is(err, target) {
+				t.Errorf("vm %t: %v is %v", vm, err, target)
+			}
+		}
+	}
+}
+
+// Runs src on both engines with limits and checks that it finishes
+func expectFinished(t *testing.T, limits runtime.Limits, src string) {
+	t.Helper()
+	for _, vm := range []bool{false, true} {
+		if _, err := quonk.New(quonk.Options{Stdout: io.Discard, VM: vm, Limits: limits}).RunString(src); err != nil {
+			t.Errorf("vm %t: %v", vm, err)
+		}
+	}
+}
+
+func TestMaxSteps(t *testing.T) {
+	expectStopped(t, context.Background(), runtime.Limits{MaxSteps: 1000}, infiniteLoop,
+		"Execution budget exceeded: more than 1000 steps evaluated",
+		[]error{runtime.ErrBudgetExceeded}, []error{runtime.ErrCancelled, runtime.ErrStackOverflow})
+	expectFinished(t, runtime.Limits{MaxSteps: 1000}, "mut n = 0;\nwhile (n < 10) { n = n + 1 }")
+}
+
+func TestTimeout(t *testing.T) {
+	expectStopped(t, context.Background(), runtime.Limits{Timeout: 20 * time.Millisecond}, infiniteLoop,
+		"Execution budget exceeded: timeout of 20ms exceeded",
+		[]error{runtime.ErrBudgetExceeded}, []error{runtime.ErrCancelled, context.DeadlineExceeded})
+}
+
+func TestContextCancel(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	expectStopped(t, ctx, runtime.Limits{}, infiniteLoop,
+		"Execution cancelled: context deadline exceeded",
+		[]error{runtime.ErrCancelled, context.DeadlineExceeded}, []error{runtime.ErrBudgetExceeded})
+
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	expectStopped(t, cancelled, runtime.Limits{}, infiniteLoop,
+		"Execution cancelled: context canceled",
+		[]error{runtime.ErrCancelled, context.Canceled}, []error{runtime.ErrBudgetExceeded})
+}
+
+// Natives calling back into QuonkScript share the budget of their caller
+func TestCallbacksShareTheBudget(t *testing.T) {
+	expectStopped(t, context.Background(), runtime.Limits{MaxSteps: 1000}, "forEach([1, 2], (x) => {\n  while (true) { }\n})",
+		"Execution budget exceeded: more than 1000 steps evaluated",
+		[]error{runtime.ErrBudgetExceeded}, nil)
+}
+
+func TestMaxCallDepth(t *testing.T) {
+	expectStopped(t, context.Background(), runtime.Limits{MaxCallDepth: 50}, deepCall,
+		"Maximum call depth exceeded: more than 50 nested calls while calling deep",
+		[]error{runtime.ErrStackOverflow, runtime.ErrBudgetExceeded}, []error{runtime.ErrCancelled})
+	expectStopped(t, context.Background(), runtime.Limits{}, deepCall,
+		"Maximum call depth exceeded: more than 10000 nested calls while calling deep",
+		[]error{runtime.ErrStackOverflow, runtime.ErrBudgetExceeded}, nil)
+
+	// Tail calls replace the call that makes them, so they do not count towards the depth
+	expectFinished(t, runtime.Limits{MaxCallDepth: 50}, "func count(n) {\n  if (n == 0) { return 0 }\n  return count(n - 1)\n}\ncount(1000)")
+	// A negative depth removes the limit
+	expectFinished(t, runtime.Limits{MaxCallDepth: -1}, "func sum(n) {\n  if (n == 0) { return 0 }\n  return n + sum(n - 1)\n}\nsum(20000)")
+}
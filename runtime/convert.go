@@ -0,0 +1,488 @@
+package runtime
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+)
+
+// Conversion between Go values and runtime values, used by host programs to pass data in and out of QuonkScript.
+//
+// Go bools, integers, floats and strings become booleans, integers, floats and strings. Slices and arrays become arrays,
+// maps with string keys and structs become objects and funcs are wrapped with WrapFunc. Pointers and interfaces
+// are converted to what they point to, a nil becomes null. Struct fields are named by their `quonk:"name"` tag,
+// or the field name if there is none, and fields tagged `quonk:"-"` or unexported fields are skipped. A value that
+// contains itself cannot be converted in either direction and is an error.
+
+var (
+	runtimeValueType = reflect.TypeOf((*RuntimeValue)(nil)).Elem()
+	errorType        = reflect.TypeOf((*error)(nil)).Elem()
+)
+
+// Converts a Go value to a runtime value. Runtime values are returned unchanged
+func ToValue(v any) (RuntimeValue, error) {
+	if value, ok := v.(RuntimeValue); ok {
+		return value, nil
+	}
+	return toValue(reflect.ValueOf(v), nil)
+}
+
+// A pointer, map or slice that is being converted. The type tells apart a struct and its first field, and the length
+// tells apart a slice and the slices of it that start at the same element
+type goReference struct {
+	t      reflect.Type
+	ptr    uintptr
+	length int
+}
+
+// visiting holds the pointers, maps and slices v is nested in, like in formatValue
+func toValue(v reflect.Value, visiting map[any]bool) (RuntimeValue, error) {
+	if !v.IsValid() {
+		return MakeNull(), nil
+	}
+	// Runtime values nested inside of Go values are kept as they are
+	if v.Kind() != reflect.Pointer && v.Kind() != reflect.Interface && v.Type().Implements(runtimeValueType) && v.CanInterface() {
+		return v.Interface().(RuntimeValue), nil
+	}
+
+	switch v.Kind() {
+	case reflect.Bool:
+		return MakeBoolean(v.Bool()), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return MakeInteger(v.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if v.Uint() > math.MaxInt64 {
+			return MakeNull(), fmt.Errorf("Cannot convert Go value %d of type %s, it is too large for an integer", v.Uint(), v.Type())
+		}
+		return MakeInteger(int64(v.Uint())), nil
+	case reflect.Float32, reflect.Float64:
+		return MakeNumber(v.Float()), nil
+	case reflect.String:
+		return MakeString(v.String()), nil
+	case reflect.Pointer, reflect.Interface, reflect.Slice, reflect.Map:
+		if v.IsNil() {
+			return MakeNull(), nil
+		}
+	}
+
+	if v.Kind() == reflect.Pointer || v.Kind() == reflect.Slice || v.Kind() == reflect.Map {
+		reference := goReference{t: v.Type(), ptr: v.Pointer()}
+		if v.Kind() == reflect.Slice {
+			reference.length = v.Len()
+		}
+		if visiting[reference] {
+			return MakeNull(), fmt.Errorf("Cannot convert Go value of type %s, it contains itself", v.Type())
+		}
+		visiting = visit(visiting, reference)
+		defer delete(visiting, reference)
+	}
+
+	switch v.Kind() {
+	case reflect.Pointer, reflect.Interface:
+		return toValue(v.Elem(), visiting)
+	case reflect.Slice, reflect.Array:
+		return sliceToValue(v, visiting)
+	case reflect.Map:
+		return mapToValue(v, visiting)
+	case reflect.Struct:
+		return structToValue(v, visiting)
+	case reflect.Func:
+		if v.IsNil() {
+			return MakeNull(), nil
+		}
+		return WrapFunc(v.Interface())
+	}
+
+	return MakeNull(), fmt.Errorf("Cannot convert Go value of type %s", v.Type())
+}
+
+func sliceToValue(v reflect.Value, visiting map[any]bool) (RuntimeValue, error) {
+	elements := make([]RuntimeValue, v.Len())
+	for i := range elements {
+		element, err := toValue(v.Index(i), visiting)
+		if err != nil {
+			return MakeNull(), fmt.Errorf("index %d: %w", i, err)
+		}
+		elements[i] = element
+	}
+	return MakeArray(elements), nil
+}
+
+func mapToValue(v reflect.Value, visiting map[any]bool) (RuntimeValue, error) {
+	if v.Type().Key().Kind() != reflect.String {
+		return MakeNull(), fmt.Errorf("Cannot convert Go map with %s keys, keys must be strings", v.Type().Key())
+	}
+
+	// Go maps are unordered, sort the keys so that the object always has the same order
+	keys := v.MapKeys()
+	sort.Slice(keys, func(i, j int) bool { return keys[i].String() < keys[j].String() })
+
+	obj := MakeObject()
+	for _, key := range keys {
+		property, err := toValue(v.MapIndex(key), visiting)
+		if err != nil {
+			return MakeNull(), fmt.Errorf("key %q: %w", key.String(), err)
+		}
+		obj.Set(key.String(), property)
+	}
+	return obj, nil
+}
+
+func structToValue(v reflect.Value, visiting map[any]bool) (RuntimeValue, error) {
+	obj := MakeObject()
+	for _, field := range structFields(v.Type()) {
+		property, err := toValue(v.Field(field.index), visiting)
+		if err != nil {
+			return MakeNull(), fmt.Errorf("field %s: %w", field.name, err)
+		}
+		obj.Set(field.name, property)
+	}
+	return obj, nil
+}
+
+type structField struct {
+	name  string // property name in QuonkScript
+	index int
+}
+
+// Fields of a struct type that are visible to QuonkScript, in declaration order
+func structFields(t reflect.Type) []structField {
+	fields := []structField{}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+
+		name := field.Name
+		if tag, ok := field.Tag.Lookup("quonk"); ok {
+			if tag == "-" {
+				continue
+			}
+			if tag != "" {
+				name = tag
+			}
+		}
+		fields = append(fields, structField{name: name, index: i})
+	}
+	return fields
+}
+
+// Converts a runtime value into the Go value that target points to, following the same rules as ToValue in reverse.
+// Numbers can only be stored in integer types if they are whole and in range, and integers can be stored in floats.
+// A target of type any receives nil, bool, int64, float64, string, []any or map[string]any, and functions are left
+// as runtime values
+func FromValue(value RuntimeValue, target any) error {
+	ptr := reflect.ValueOf(target)
+	if ptr.Kind() != reflect.Pointer || ptr.IsNil() {
+		return fmt.Errorf("Cannot convert into %T, target must be a non-nil pointer", target)
+	}
+	return fromValue(value, ptr.Elem(), nil)
+}
+
+// visiting holds the contents of the arrays and objects value is nested in, like in formatValue
+func fromValue(value RuntimeValue, target reflect.Value, visiting map[any]bool) error {
+	if variable, ok := value.(VariableValue); ok {
+		value = variable.GetValue()
+	}
+
+	if target.Kind() == reflect.Interface && target.NumMethod() == 0 {
+		goValue, err := toGo(value, visiting)
+		if err != nil {
+			return err
+		}
+		if goValue != nil {
+			target.Set(reflect.ValueOf(goValue))
+		} else {
+			target.SetZero()
+		}
+		return nil
+	}
+	// Covers RuntimeValue itself as well as concrete types like StringValue
+	if reflect.TypeOf(value).AssignableTo(target.Type()) {
+		target.Set(reflect.ValueOf(value))
+		return nil
+	}
+
+	switch target.Kind() {
+	case reflect.Pointer:
+		if value.GetType() == NullValueType {
+			target.SetZero()
+			return nil
+		}
+		elem := reflect.New(target.Type().Elem())
+		if err := fromValue(value, elem.Elem(), visiting); err != nil {
+			return err
+		}
+		target.Set(elem)
+		return nil
+	case reflect.Bool:
+		if value.GetType() == BooleanValueType {
+			target.SetBool(value.(BooleanValue).Value)
+			return nil
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if value.GetType() == IntegerValueType {
+			n := value.(IntegerValue).Value
+			if target.OverflowInt(n) {
+				return fmt.Errorf("Cannot convert integer %d to %s", n, target.Type())
+			}
+			target.SetInt(n)
+			return nil
+		}
+		if value.GetType() == NumberValueType {
+			n := value.(NumberValue).Value
+			if n != math.Trunc(n) || n < math.MinInt64 || n >= math.MaxInt64 || target.OverflowInt(int64(n)) {
+				return fmt.Errorf("Cannot convert float %v to %s", n, target.Type())
+			}
+			target.SetInt(int64(n))
+			return nil
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if value.GetType() == IntegerValueType {
+			n := value.(IntegerValue).Value
+			if n < 0 || target.OverflowUint(uint64(n)) {
+				return fmt.Errorf("Cannot convert integer %d to %s", n, target.Type())
+			}
+			target.SetUint(uint64(n))
+			return nil
+		}
+		if value.GetType() == NumberValueType {
+			n := value.(NumberValue).Value
+			if n != math.Trunc(n) || n < 0 || n >= math.MaxUint64 || target.OverflowUint(uint64(n)) {
+				return fmt.Errorf("Cannot convert float %v to %s", n, target.Type())
+			}
+			target.SetUint(uint64(n))
+			return nil
+		}
+	case reflect.Float32, reflect.Float64:
+		if IsNumber(value) {
+			n, _ := ToFloat(value)
+			if target.OverflowFloat(n) {
+				return fmt.Errorf("Cannot convert %s %v to %s", value.GetType(), n, target.Type())
+			}
+			target.SetFloat(n)
+			return nil
+		}
+	case reflect.String:
+		if value.GetType() == StringValueType {
+			target.SetString(value.(StringValue).Value)
+			return nil
+		}
+	case reflect.Slice:
+		if value.GetType() == NullValueType {
+			target.SetZero()
+			return nil
+		}
+		if value.GetType() == ArrayValueType {
+			arr := value.(ArrayValue)
+			slice := reflect.MakeSlice(target.Type(), arr.Len(), arr.Len())
+			if err := fromArray(arr, slice, visiting); err != nil {
+				return err
+			}
+			target.Set(slice)
+			return nil
+		}
+	case reflect.Array:
+		if value.GetType() == ArrayValueType {
+			arr := value.(ArrayValue)
+			if arr.Len() != target.Len() {
+				return fmt.Errorf("Cannot convert array of length %d to %s", arr.Len(), target.Type())
+			}
+			return fromArray(arr, target, visiting)
+		}
+	case reflect.Map:
+		if value.GetType() == NullValueType {
+			target.SetZero()
+			return nil
+		}
+		if value.GetType() == ObjectValueType && target.Type().Key().Kind() == reflect.String {
+			obj := value.(ObjectValue)
+			visiting, err := enter(visiting, obj.Properties, value)
+			if err != nil {
+				return err
+			}
+			defer delete(visiting, obj.Properties)
+
+			m := reflect.MakeMapWithSize(target.Type(), obj.Len())
+			for _, key := range obj.Keys() {
+				elem := reflect.New(target.Type().Elem()).Elem()
+				if err := fromValue(obj.Get(key), elem, visiting); err != nil {
+					return fmt.Errorf("key %q: %w", key, err)
+				}
+				m.SetMapIndex(reflect.ValueOf(key).Convert(target.Type().Key()), elem)
+			}
+			target.Set(m)
+			return nil
+		}
+	case reflect.Struct:
+		if value.GetType() == ObjectValueType {
+			obj := value.(ObjectValue)
+			visiting, err := enter(visiting, obj.Properties, value)
+			if err != nil {
+				return err
+			}
+			defer delete(visiting, obj.Properties)
+
+			// Properties the struct does not have are ignored and fields the object does not have are left alone
+			for _, field := range structFields(target.Type()) {
+				property := obj.Get(field.name)
+				if property == nil {
+					continue
+				}
+				if err := fromValue(property, target.Field(field.index), visiting); err != nil {
+					return fmt.Errorf("field %s: %w", field.name, err)
+				}
+			}
+			return nil
+		}
+	}
+
+	return fmt.Errorf("Cannot convert %s to %s", value.GetType(), target.Type())
+}
+
+func fromArray(arr ArrayValue, target reflect.Value, visiting map[any]bool) error {
+	visiting, err := enter(visiting, arr.Elements, arr)
+	if err != nil {
+		return err
+	}
+	defer delete(visiting, arr.Elements)
+
+	for i := 0; i < arr.Len(); i++ {
+		if err := fromValue(arr.Get(i), target.Index(i), visiting); err != nil {
+			return fmt.Errorf("index %d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// Marks the contents of an array or object as being converted. Fails if they already are, as value then contains itself
+func enter(visiting map[any]bool, contents any, value RuntimeValue) (map[any]bool, error) {
+	if visiting[contents] {
+		return visiting, fmt.Errorf("Cannot convert %s that contains itself", value.GetType())
+	}
+	return visit(visiting, contents), nil
+}
+
+// Natural Go representation of a runtime value, used when converting into an empty interface
+func toGo(value RuntimeValue, visiting map[any]bool) (any, error) {
+	switch value.GetType() {
+	case NullValueType:
+		return nil, nil
+	case BooleanValueType:
+		return value.(BooleanValue).Value, nil
+	case NumberValueType:
+		return value.(NumberValue).Value, nil
+	case IntegerValueType:
+		return value.(IntegerValue).Value, nil
+	case StringValueType:
+		return value.(StringValue).Value, nil
+	case ArrayValueType:
+		arr := value.(ArrayValue)
+		visiting, err := enter(visiting, arr.Elements, value)
+		if err != nil {
+			return nil, err
+		}
+		defer delete(visiting, arr.Elements)
+
+		slice := make([]any, arr.Len())
+		for i := range slice {
+			element, err := toGo(arr.Get(i), visiting)
+			if err != nil {
+				return nil, fmt.Errorf("index %d: %w", i, err)
+			}
+			slice[i] = element
+		}
+		return slice, nil
+	case ObjectValueType:
+		obj := value.(ObjectValue)
+		visiting, err := enter(visiting, obj.Properties, value)
+		if err != nil {
+			return nil, err
+		}
+		defer delete(visiting, obj.Properties)
+
+		m := make(map[string]any, obj.Len())
+		for _, key := range obj.Keys() {
+			property, err := toGo(obj.Get(key), visiting)
+			if err != nil {
+				return nil, fmt.Errorf("key %q: %w", key, err)
+			}
+			m[key] = property
+		}
+		return m, nil
+	case VariableValueType:
+		return toGo(value.(VariableValue).GetValue(), visiting)
+	case FunctionValueType, InternalFunctionValueType:
+		return value, nil
+	}
+	return nil, fmt.Errorf("Cannot convert %s to a Go value", value.GetType())
+}
+
+// Wraps a Go func so that it can be called from QuonkScript. Arguments are converted with FromValue and checked
+// against the parameters of fn, which may be variadic. fn may return nothing, a value, an error, or a value and an
+// error. The value is converted with ToValue and a non-nil error is returned to the interpreter, which raises it
+func WrapFunc(fn any) (InternalFunctionValue, error) {
+	v := reflect.ValueOf(fn)
+	if v.Kind() != reflect.Func || v.IsNil() {
+		return InternalFunctionValue{}, fmt.Errorf("Cannot wrap %T, it is not a func", fn)
+	}
+
+	t := v.Type()
+	returnsError := t.NumOut() > 0 && t.Out(t.NumOut()-1) == errorType
+	results := t.NumOut()
+	if returnsError {
+		results--
+	}
+	if results > 1 {
+		return InternalFunctionValue{}, fmt.Errorf("Cannot wrap %s, it must return at most one value and an error", t)
+	}
+
+	return MakeFunction(func(args []RuntimeValue, scope *Scope) (RuntimeValue, error) {
+		in, err := funcArgs(t, args)
+		if err != nil {
+			return nil, err
+		}
+
+		out := v.Call(in)
+		if returnsError {
+			if err, _ := out[len(out)-1].Interface().(error); err != nil {
+				return nil, err
+			}
+		}
+		if results == 0 {
+			return MakeNull(), nil
+		}
+
+		return toValue(out[0], nil)
+	}), nil
+}
+
+// Checks and converts the arguments of a call to a func of type t
+func funcArgs(t reflect.Type, args []RuntimeValue) ([]reflect.Value, error) {
+	params := t.NumIn()
+	if t.IsVariadic() {
+		if len(args) < params-1 {
+			return nil, fmt.Errorf("Function expects at least %d arguments but was called with %d", params-1, len(args))
+		}
+	} else if len(args) != params {
+		return nil, fmt.Errorf("Function expects %d arguments but was called with %d", params, len(args))
+	}
+
+	in := make([]reflect.Value, len(args))
+	for i, arg := range args {
+		var param reflect.Type
+		if t.IsVariadic() && i >= params-1 {
+			param = t.In(params - 1).Elem()
+		} else {
+			param = t.In(i)
+		}
+
+		in[i] = reflect.New(param).Elem()
+		if err := fromValue(arg, in[i], nil); err != nil {
+			return nil, fmt.Errorf("Argument %d: %w", i+1, err)
+		}
+	}
+	return in, nil
+}
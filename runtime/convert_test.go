@@ -0,0 +1,302 @@
+package runtime
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func mustToValue(t *testing.T, v any) RuntimeValue {
+	t.Helper()
+	value, err := ToValue(v)
+	if err != nil {
+		t.Fatalf("ToValue(%#v): %v", v, err)
+	}
+	return value
+}
+
+func expectFormatted(t *testing.T, value RuntimeValue, want string) {
+	t.Helper()
+	if got := formatValue(value, nil); got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
+
+func expectErrorMessage(t *testing.T, err error, want string) {
+	t.Helper()
+	if err == nil || err.Error() != want {
+		t.Errorf("got error %v, want %s", err, want)
+	}
+}
+
+func TestConvertScalars(t *testing.T) {
+	n := 7
+	for _, test := range []struct {
+		in   any
+		want string
+	}{
+		{true, "true"},
+		{int8(-3), "-3"},
+		{uint16(65535), "65535"},
+		{float32(2.5), "2.5"},
+		{3.0, "3.0"},
+		{"héllo", "héllo"},
+		{nil, "null"},
+		{&n, "7"},
+		{(*int)(nil), "null"},
+		{MakeString("kept"), "kept"},
+	} {
+		expectFormatted(t, mustToValue(t, test.in), test.want)
+	}
+
+	var b bool
+	var i int
+	var f float64
+	var s string
+	var p *int
+	for _, test := range []struct {
+		value  RuntimeValue
+		target any
+		want   any
+	}{
+		{MakeBoolean(true), &b, true},
+		{MakeInteger(-42), &i, -42},
+		{MakeNumber(6), &i, 6},
+		{MakeInteger(3), &f, 3.0},
+		{MakeNumber(0.5), &f, 0.5},
+		{MakeString("s"), &s, "s"},
+	} {
+		if err := FromValue(test.value, test.target); err != nil {
+			t.Errorf("FromValue(%s): %v", formatValue(test.value, nil), err)
+			continue
+		}
+		if got := reflect.ValueOf(test.target).Elem().Interface(); got != test.want {
+			t.Errorf("FromValue(%s) stored %v, want %v", formatValue(test.value, nil), got, test.want)
+		}
+	}
+
+	if err := FromValue(MakeInteger(5), &p); err != nil || p == nil || *p != 5 {
+		t.Errorf("FromValue into a pointer stored %v, %v", p, err)
+	}
+	if err := FromValue(MakeNull(), &p); err != nil || p != nil {
+		t.Errorf("FromValue of null into a pointer stored %v, %v", p, err)
+	}
+	expectErrorMessage(t, FromValue(MakeString("x"), &i), "Cannot convert string to int")
+	expectErrorMessage(t, FromValue(MakeInteger(1), i), "Cannot convert into int, target must be a non-nil pointer")
+}
+
+func TestConvertIntegerOverflow(t *testing.T) {
+	var i8 int8
+	var u uint
+	var u8 uint8
+	var i int
+	expectErrorMessage(t, FromValue(MakeInteger(128), &i8), "Cannot convert integer 128 to int8")
+	expectErrorMessage(t, FromValue(MakeInteger(-129), &i8), "Cannot convert integer -129 to int8")
+	expectErrorMessage(t, FromValue(MakeInteger(-1), &u), "Cannot convert integer -1 to uint")
+	expectErrorMessage(t, FromValue(MakeInteger(256), &u8), "Cannot convert integer 256 to uint8")
+	expectErrorMessage(t, FromValue(MakeNumber(1.5), &i), "Cannot convert float 1.5 to int")
+	expectErrorMessage(t, FromValue(MakeNumber(1e19), &i), "Cannot convert float 1e+19 to int")
+	expectErrorMessage(t, FromValue(MakeNumber(300), &u8), "Cannot convert float 300 to uint8")
+
+	if err := FromValue(MakeInteger(127), &i8); err != nil || i8 != 127 {
+		t.Errorf("FromValue(127) stored %d, %v", i8, err)
+	}
+
+	_, err := ToValue(uint64(1) << 63)
+	expectErrorMessage(t, err, "Cannot convert Go value 9223372036854775808 of type uint64, it is too large for an integer")
+}
+
+type person struct {
+	Name    string `quonk:"name"`
+	Age     int
+	Secret  string `quonk:"-"`
+	hidden  int
+	Friends []string `quonk:"friends"`
+}
+
+func TestConvertStructs(t *testing.T) {
+	value := mustToValue(t, person{Name: "Ann", Age: 30, Secret: "s", hidden: 1, Friends: []string{"Bo"}})
+	expectFormatted(t, value, `{"name": "Ann", "Age": 30, "friends": ["Bo"]}`)
+
+	obj := MakeObject()
+	obj.Set("name", MakeString("Cy"))
+	obj.Set("Secret", MakeString("ignored"))
+	obj.Set("hidden", MakeInteger(9))
+	obj.Set("unknown", MakeInteger(1))
+	p := person{Age: 40, Secret: "kept"}
+	if err := FromValue(obj, &p); err != nil {
+		t.Fatal(err)
+	}
+	if want := (person{Name: "Cy", Age: 40, Secret: "kept"}); !reflect.DeepEqual(p, want) {
+		t.Errorf("FromValue stored %+v, want %+v", p, want)
+	}
+
+	obj.Set("Age", MakeString("old"))
+	expectErrorMessage(t, FromValue(obj, &p), "field Age: Cannot convert string to int")
+}
+
+func TestConvertMapsAndSlices(t *testing.T) {
+	value := mustToValue(t, map[string][]int{"b": {1, 2}, "a": nil, "c": {}})
+	expectFormatted(t, value, `{"a": null, "b": [1, 2], "c": []}`)
+	expectFormatted(t, mustToValue(t, [2]bool{true, false}), "[true, false]")
+
+	_, err := ToValue(map[int]string{1: "a"})
+	expectErrorMessage(t, err, "Cannot convert Go map with int keys, keys must be strings")
+
+	var m map[string][]int
+	if err := FromValue(value, &m); err != nil {
+		t.Fatal(err)
+	}
+	if want := (map[string][]int{"a": nil, "b": {1, 2}, "c": {}}); !reflect.DeepEqual(m, want) {
+		t.Errorf("FromValue stored %v, want %v", m, want)
+	}
+
+	var anything any
+	if err := FromValue(value, &anything); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]any{"a": nil, "b": []any{int64(1), int64(2)}, "c": []any{}}
+	if !reflect.DeepEqual(anything, want) {
+		t.Errorf("FromValue into any stored %#v, want %#v", anything, want)
+	}
+
+	var pair [2]int
+	expectErrorMessage(t, FromValue(MakeArray([]RuntimeValue{MakeInteger(1)}), &pair), "Cannot convert array of length 1 to [2]int")
+	var strings []string
+	expectErrorMessage(t, FromValue(MakeArray([]RuntimeValue{MakeString("a"), MakeInteger(1)}), &strings), "index 1: Cannot convert integer to string")
+}
+
+func call(t *testing.T, fn any, args ...RuntimeValue) (RuntimeValue, error) {
+	t.Helper()
+	wrapped, err := WrapFunc(fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return wrapped.Func(args, nil)
+}
+
+func TestWrapFuncChecksArguments(t *testing.T) {
+	repeat := func(s string, n int) string {
+		result := ""
+		for i := 0; i < n; i++ {
+			result += s
+		}
+		return result
+	}
+
+	result, err := call(t, repeat, MakeString("ab"), MakeInteger(3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectFormatted(t, result, "ababab")
+
+	_, err = call(t, repeat, MakeString("ab"))
+	expectErrorMessage(t, err, "Function expects 2 arguments but was called with 1")
+	_, err = call(t, repeat, MakeString("ab"), MakeInteger(1), MakeInteger(2))
+	expectErrorMessage(t, err, "Function expects 2 arguments but was called with 3")
+	_, err = call(t, repeat, MakeInteger(1), MakeInteger(3))
+	expectErrorMessage(t, err, "Argument 1: Cannot convert integer to string")
+
+	_, err = WrapFunc(42)
+	expectErrorMessage(t, err, "Cannot wrap int, it is not a func")
+	_, err = WrapFunc(func() (int, int) { return 1, 2 })
+	expectErrorMessage(t, err, "Cannot wrap func() (int, int), it must return at most one value and an error")
+}
+
+func TestWrapFuncVariadic(t *testing.T) {
+	sum := func(start int, rest ...int) int {
+		for _, n := range rest {
+			start += n
+		}
+		return start
+	}
+
+	for _, test := range []struct {
+		args []RuntimeValue
+		want string
+	}{
+		{[]RuntimeValue{MakeInteger(1)}, "1"},
+		{[]RuntimeValue{MakeInteger(1), MakeInteger(2), MakeNumber(3)}, "6"},
+	} {
+		result, err := call(t, sum, test.args...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expectFormatted(t, result, test.want)
+	}
+
+	_, err := call(t, sum)
+	expectErrorMessage(t, err, "Function expects at least 1 arguments but was called with 0")
+	_, err = call(t, sum, MakeInteger(1), MakeInteger(2), MakeString("3"))
+	expectErrorMessage(t, err, "Argument 3: Cannot convert string to int")
+}
+
+func TestWrapFuncReturnsError(t *testing.T) {
+	errBoom := errors.New("boom")
+	fail := func(fail bool) (int, error) {
+		if fail {
+			return 0, errBoom
+		}
+		return 1, nil
+	}
+
+	_, err := call(t, fail, MakeBoolean(true))
+	if !errors.Is(err, errBoom) {
+		t.Errorf("got error %v, want %v", err, errBoom)
+	}
+	result, err := call(t, fail, MakeBoolean(false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectFormatted(t, result, "1")
+
+	result, err = call(t, func() {})
+	if err != nil || result.GetType() != NullValueType {
+		t.Errorf("a func returning nothing returned %v, %v", result, err)
+	}
+}
+
+type node struct {
+	Value int
+	Next  *node
+}
+
+func TestConvertCycles(t *testing.T) {
+	loop := &node{Value: 1}
+	loop.Next = loop
+	_, err := ToValue(loop)
+	expectErrorMessage(t, err, "field Next: Cannot convert Go value of type *runtime.node, it contains itself")
+
+	m := map[string]any{}
+	m["self"] = m
+	_, err = ToValue(m)
+	expectErrorMessage(t, err, `key "self": Cannot convert Go value of type map[string]interface {}, it contains itself`)
+
+	s := []any{1}
+	s[0] = s
+	_, err = ToValue(s)
+	expectErrorMessage(t, err, "index 0: Cannot convert Go value of type []interface {}, it contains itself")
+
+	// The same value twice is not a cycle
+	shared := &node{Value: 2}
+	expectFormatted(t, mustToValue(t, []*node{shared, shared}), `[{"Value": 2, "Next": null}, {"Value": 2, "Next": null}]`)
+
+	// mut a = [1]; push(a, a)
+	arr := MakeArray([]RuntimeValue{MakeInteger(1)})
+	*arr.Elements = append(*arr.Elements, arr)
+	var anything any
+	expectErrorMessage(t, FromValue(arr, &anything), "index 1: Cannot convert array that contains itself")
+	var values []any
+	expectErrorMessage(t, FromValue(arr, &values), "index 1: Cannot convert array that contains itself")
+
+	obj := MakeObject()
+	obj.Set("self", obj)
+	var tree map[string]any
+	expectErrorMessage(t, FromValue(obj, &tree), `key "self": Cannot convert object that contains itself`)
+
+	// Runtime values are stored as they are, which needs no recursion
+	var kept []RuntimeValue
+	if err := FromValue(arr, &kept); err != nil || len(kept) != 2 {
+		t.Errorf("FromValue into []RuntimeValue stored %v, %v", kept, err)
+	}
+}
@@ -0,0 +1,118 @@
+package runtime
+
+import (
+	"QuonkScript/lexer"
+	"QuonkScript/parser"
+	"fmt"
+	"strings"
+)
+
+// A single QuonkScript function call on the call stack
+type Frame struct {
+	Function string         // name of the function that was called
+	CallSite lexer.Position // where the function was called from
+}
+
+// RuntimeError is an error raised by the QuonkScript program being evaluated, as opposed to a bug in the interpreter
+type RuntimeError struct {
+	Message string
+	Pos     lexer.Position // where in the source the error was raised
+	Stack   []Frame        // QuonkScript calls active when the error was raised, most recent call last
+	Err     error          // underlying error, if any
+}
+
+func (e *RuntimeError) Error() string {
+	if e.Pos == (lexer.Position{}) {
+		// Raised by a call made from Go, so there is no source location
+		return fmt.Sprintf("Honk! %s", e.Message)
+	}
+	return fmt.Sprintf("Honk! %s: %s", e.Pos, e.Message)
+}
+
+func (e *RuntimeError) Unwrap() error {
+	return e.Err
+}
+
+// Times a line of a traceback is repeated before the rest of the repeats are summarised, so that deep recursion
+// does not print thousands of identical lines
+const maxRepeatedTraceLines = 3
+
+// Formats the error along with the QuonkScript calls that led to it, most recent call last
+func (e *RuntimeError) Traceback() string {
+	lines := []string{}
+
+	// Each frame was called from somewhere inside of the function before it
+	function := "<main>"
+	for _, frame := range e.Stack {
+		if frame.CallSite == (lexer.Position{}) && function == NativeFunctionName {
+			lines = append(lines, "called from a native function")
+		} else if frame.CallSite == (lexer.Position{}) {
+			lines = append(lines, "called from Go")
+		} else {
+			lines = append(lines, fmt.Sprintf("at %s, in %s", frame.CallSite, function))
+		}
+		function = frame.Function
+	}
+	if e.Pos != (lexer.Position{}) {
+		lines = append(lines, fmt.Sprintf("at %s, in %s", e.Pos, function))
+	}
+
+	var b strings.Builder
+	b.WriteString("Traceback (most recent call last):\n")
+	for i := 0; i < len(lines); {
+		repeats := 1
+		for i+repeats < len(lines) && lines[i+repeats] == lines[i] {
+			repeats++
+		}
+
+		for j := 0; j < repeats && j < maxRepeatedTraceLines; j++ {
+			fmt.Fprintf(&b, "  %s\n", lines[i])
+		}
+		if repeats > maxRepeatedTraceLines {
+			fmt.Fprintf(&b, "  [previous line repeated %d more times]\n", repeats-maxRepeatedTraceLines)
+		}
+		i += repeats
+	}
+	b.WriteString(e.Error())
+
+	return b.String()
+}
+
+// Aborts evaluation with a RuntimeError located at node. The error is returned to the caller of Run
+func raise(scope *Scope, node parser.Node, format string, args ...any) {
+	panic(newRuntimeError(scope, node, fmt.Sprintf(format, args...), nil))
+}
+
+// Like raise, but wraps an existing error
+func raiseError(scope *Scope, node parser.Node, err error) {
+	panic(scope.State().Error(positionOf(node), err))
+}
+
+func newRuntimeError(scope *Scope, node parser.Node, message string, err error) *RuntimeError {
+	return &RuntimeError{Message: message, Pos: positionOf(node), Stack: scope.State().snapshotStack(), Err: err}
+}
+
+// Turns err into a RuntimeError raised at pos with the current stack. A RuntimeError is returned unchanged,
+// it was raised by a function called further down and already has the position and stack of where it happened
+func (s *State) Error(pos lexer.Position, err error) *RuntimeError {
+	if runtimeErr, ok := err.(*RuntimeError); ok {
+		return runtimeErr
+	}
+
+	return &RuntimeError{Message: err.Error(), Pos: pos, Stack: s.snapshotStack(), Err: err}
+}
+
+// Copy of the current stack for an error, since the stack keeps changing after the error is raised
+func (s *State) snapshotStack() []Frame {
+	stack := make([]Frame, len(s.Frames))
+	copy(stack, s.Frames)
+	return stack
+}
+
+// Position of node in the source. Errors raised by calls made from Go have no node, which gives the zero Position
+func positionOf(node parser.Node) lexer.Position {
+	if node == nil {
+		return lexer.Position{}
+	}
+	return node.GetPos()
+}
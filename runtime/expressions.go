@@ -1,64 +1,82 @@
 package runtime
 
 import (
+	"QuonkScript/lexer"
 	"QuonkScript/parser"
-	"fmt"
 )
 
 func evalBinaryExpr(expr parser.BinaryExpr, scope *Scope) RuntimeValue {
 	leftHandSide := Evaluate(expr.Left, scope)
 	rightHandSide := Evaluate(expr.Right, scope)
 
-	if leftHandSide.GetType() == NumberValueType && rightHandSide.GetType() == NumberValueType {
-		return evalNumericBinaryExpr(leftHandSide.(NumberValue), rightHandSide.(NumberValue), expr.Operator)
-	} else {
-		return NullValue{TypedValue: TypedValue{Type: NullValueType}, Value: nil}
+	result, err := BinaryOp(expr.Operator, leftHandSide, rightHandSide)
+	if err != nil {
+		raiseError(scope, expr, err)
 	}
+	return result
 }
 
-func evalNumericBinaryExpr(left NumberValue, right NumberValue, operator string) RuntimeValue {
-	num := 0.0
+func evalUnaryExpr(expr parser.UnaryExpr, scope *Scope) RuntimeValue {
+	operand := Evaluate(expr.Operand, scope)
 
-	if operator == "+" {
-		num = left.Value + right.Value
-	} else if operator == "-" {
-		num = left.Value - right.Value
-	} else if operator == "*" {
-		num = left.Value * right.Value
-	} else if operator == "/" {
-		// TODO: implement zero check
-		num = left.Value / right.Value
-	} else if operator == "%" {
-		// cast to int for mod
-		num = float64(int(left.Value) % int(right.Value))
+	result, err := UnaryOp(expr.Operator, operand)
+	if err != nil {
+		raiseError(scope, expr, err)
 	}
-
-	return MakeNumber(num)
+	return result
 }
 
 func evalIdentifier(ident parser.Ident, scope *Scope) RuntimeValue {
-	val := scope.LookupVariable(ident.Symbol)
+	val, err := lookupBinding(ident.Binding, ident.Symbol, scope)
+	if err != nil {
+		raiseError(scope, ident, err)
+	}
 	return val
 }
 
 func evalAssignmentExpr(expr parser.VarAssignmentExpr, scope *Scope) RuntimeValue {
-	if expr.Assignee.GetKind() != parser.IdentifierNode { // will check for objects in the future
-		panic("Honk! Attempt to assign value to something other than an identifier")
+	if expr.Assignee.GetKind() == parser.MemberExprNode {
+		return evalMemberAssignment(expr.Assignee.(parser.MemberExpr), expr.Value, scope)
+	}
+	if expr.Assignee.GetKind() != parser.IdentifierNode {
+		raise(scope, expr.Assignee, "Attempt to assign value to something other than an identifier or member")
+	}
+	assignee := expr.Assignee.(parser.Ident)
+	value := Evaluate(expr.Value, scope)
+
+	var err error
+	if assignee.Binding.Local {
+		value, err = scope.AssignSlot(assignee.Binding.Depth, assignee.Binding.Slot, assignee.Symbol, value)
+	} else {
+		value, err = scope.AssignVariable(assignee.Symbol, value)
+	}
+	if err != nil {
+		raiseError(scope, expr, err)
 	}
-	varname := expr.Assignee.(parser.Ident).Symbol
-	// This cast is safe as we are not implementing objects yet
-	return scope.AssignVariable(varname, Evaluate(expr.Value, scope))
+	return value
+}
+
+// Reads a variable from the slot the resolver bound it to, or by name if it was not bound
+func lookupBinding(binding parser.Binding, name string, scope *Scope) (RuntimeValue, error) {
+	if binding.Local {
+		return scope.LookupSlot(binding.Depth, binding.Slot, name)
+	}
+	return scope.LookupVariable(name)
 }
 
 func evalObjectExpr(object parser.ObjectLiteral, scope *Scope) RuntimeValue {
-	obj := ObjectValue{TypedValue: TypedValue{Type: ObjectValueType}, Properties: make(map[string]RuntimeValue)}
+	obj := MakeObject()
 	var val RuntimeValue
 	for _, propertyLiteral := range object.Properties {
 		key := propertyLiteral.Key
 		value := propertyLiteral.Value
 		// { key }
 		if value == nil {
-			val = scope.LookupVariable(key)
+			var err error
+			val, err = lookupBinding(propertyLiteral.Binding, key, scope)
+			if err != nil {
+				raiseError(scope, propertyLiteral, err)
+			}
 		} else {
 			// Dereference pointer
 			val = Evaluate(*value, scope)
@@ -68,7 +86,64 @@ func evalObjectExpr(object parser.ObjectLiteral, scope *Scope) RuntimeValue {
 	return obj
 }
 
+func evalArrayExpr(array parser.ArrayLiteral, scope *Scope) RuntimeValue {
+	elements := make([]RuntimeValue, 0, len(array.Elements))
+	for _, element := range array.Elements {
+		elements = append(elements, Evaluate(element, scope))
+	}
+	return MakeArray(elements)
+}
+
+func evalMemberExpr(expr parser.MemberExpr, scope *Scope) RuntimeValue {
+	obj := Evaluate(expr.Object, scope)
+	if err := CheckMemberTarget(obj, false); err != nil {
+		raiseError(scope, expr.Object, err)
+	}
+
+	value, err := GetMember(obj, evalMemberKey(expr, scope), expr.Computed)
+	if err != nil {
+		raiseError(scope, expr.Field, err)
+	}
+	return value
+}
+
+// Handles assignments like arr[i] = value, obj.field = value and obj["field"] = value
+func evalMemberAssignment(assignee parser.MemberExpr, valueExpr parser.Expr, scope *Scope) RuntimeValue {
+	obj := Evaluate(assignee.Object, scope)
+	if err := CheckMemberTarget(obj, true); err != nil {
+		raiseError(scope, assignee.Object, err)
+	}
+
+	// The key is checked before the value is evaluated
+	key := evalMemberKey(assignee, scope)
+	if err := CheckMemberKey(obj, key, assignee.Computed); err != nil {
+		raiseError(scope, assignee.Field, err)
+	}
+
+	value, err := SetMember(obj, key, assignee.Computed, Evaluate(valueExpr, scope))
+	if err != nil {
+		raiseError(scope, assignee.Field, err)
+	}
+	return value
+}
+
+// Evaluates the field of obj[expr], the field of obj.field is its name as a string
+func evalMemberKey(expr parser.MemberExpr, scope *Scope) RuntimeValue {
+	if !expr.Computed {
+		// The parser only allows identifiers after a dot
+		return MakeString(expr.Field.(parser.Ident).Symbol)
+	}
+	return Evaluate(expr.Field, scope)
+}
+
 func evalCallExpr(call parser.InternalFunctionCallExpr, scope *Scope) RuntimeValue {
+	fn, args := evalCallee(call, scope)
+
+	return callFunction(fn, args, scope, call)
+}
+
+// Evaluates the function and arguments of a call without calling it
+func evalCallee(call parser.InternalFunctionCallExpr, scope *Scope) (RuntimeValue, []RuntimeValue) {
 	args := make([]RuntimeValue, 0)
 	for _, arg := range call.Args {
 		// Evaluate all args
@@ -77,88 +152,106 @@ func evalCallExpr(call parser.InternalFunctionCallExpr, scope *Scope) RuntimeVal
 	// Get runtime value for caller function
 	fn := Evaluate(call.Caller, scope)
 
+	return fn, args
+}
+
+// Calls a native function with a frame of its own, so that errors in QuonkScript functions it calls back into
+// show where it was called from. A nil result is returned as null
+func CallNative(fn InternalFunctionValue, args []RuntimeValue, scope *Scope, callSite lexer.Position) (RuntimeValue, error) {
+	state := scope.State()
+	state.Frames = append(state.Frames, Frame{Function: NativeFunctionName, CallSite: callSite})
+	result, err := fn.Func(args, scope)
+	state.Frames = state.Frames[:len(state.Frames)-1]
+
+	if err != nil {
+		return nil, err
+	}
+	if result == nil {
+		return MakeNull(), nil
+	}
+	return result, nil
+}
+
+// Calls a native or QuonkScript function. callSite is the node the call came from, or nil if it came from Go
+func callFunction(fn RuntimeValue, args []RuntimeValue, scope *Scope, callSite parser.Node) RuntimeValue {
+	// Tail calls made by the function are made here, taking over the frame of the call that made them.
+	// The frame keeps its call site, so tracebacks skip functions that finished with a tail call
+	frameSite := positionOf(callSite)
+	for {
+		result := callOnce(fn, args, scope, callSite, frameSite)
+
+		tailCall, ok := result.(tailCallSignal)
+		if !ok {
+			return result
+		}
+		fn, args, callSite = tailCall.Fn, tailCall.Args, tailCall.Call
+	}
+}
+
+// Makes a single call, the result is a tailCallSignal if the function ended with a call in tail position.
+// Errors are raised at callSite and the call's frame is called from frameSite
+func callOnce(fn RuntimeValue, args []RuntimeValue, scope *Scope, callSite parser.Node, frameSite lexer.Position) RuntimeValue {
 	if fn.GetType() == InternalFunctionValueType {
-		// Call function
-		fn.(InternalFunctionValue).Func(args, scope)
-		return MakeNull()
+		result, err := CallNative(fn.(InternalFunctionValue), args, scope, frameSite)
+		if err != nil {
+			raiseError(scope, callSite, err)
+		}
+		return result
 	} else if fn.GetType() == FunctionValueType {
 		function := fn.(FunctionValue)
-		// Inherits from function
-		functionScope := &Scope{Parent: function.DeclarationScope, Variables: map[string]Variable{}}
-
-		if len(args) != len(function.Params) {
-			panic(fmt.Sprintf("Honk! Too few arguments for call of function %s", function.Name))
+		if function.Compiled != nil {
+			result, err := function.Compiled.Call(function, args, scope, frameSite)
+			if err != nil {
+				raiseError(scope, callSite, err)
+			}
+			return result
 		}
-
-		// Populate scope
-		for i, param := range function.Params {
-			// we have already created the runtime value
-			functionScope.DeclareVariable(param, args[i], false)
+		state := scope.State()
+		functionScope, err := state.EnterCall(function, args, frameSite, false)
+		if err != nil {
+			raiseError(scope, callSite, err)
 		}
 
-		// I am not sure about this
-		// What about early returns
-		var result RuntimeValue = MakeNull()
-
-		// Evaluate each statement -- Check for return here maybe? How to handle breaking out of all calls
-		for _, stmt := range function.Body {
-			result = Evaluate(stmt, functionScope)
+		// The result of a call is whatever is returned, or the last evaluated statement if nothing is
+		result := evalFunctionBody(function.Body, functionScope)
+		if signal, ok := result.(returnSignal); ok {
+			result = signal.Value
+		} else if _, ok := result.(tailCallSignal); !ok && result.GetType() == ControlValueType {
+			raiseStrayControl(scope, result)
 		}
+		// No defer here, if evaluation is aborted the frames are still needed for the traceback and Run resets them
+		state.Frames = state.Frames[:len(state.Frames)-1]
 
 		return result
 	} else {
-		panic("Honk! Cannot call non-function value")
+		raise(scope, callSite, "Cannot call non-function value of type %s", fn.GetType())
+		return nil // unreachable, raise does not return
 	}
-
 }
 
 func evalComparisonExpr(expr parser.ComparisonExpr, scope *Scope) RuntimeValue {
 	left := Evaluate(expr.Left, scope)
 	right := Evaluate(expr.Right, scope)
 
-	if left.GetType() == BooleanValueType && right.GetType() == BooleanValueType {
-		return evalBooleanComparisonExpr(left.(BooleanValue), right.(BooleanValue), expr.Operator)
-	} else if left.GetType() == NumberValueType && right.GetType() == NumberValueType {
-		return evalNumericComparisonExpr(left.(NumberValue), right.(NumberValue), expr.Operator)
-	} else {
-		return MakeNull()
-	}
-
-}
-
-func evalBooleanComparisonExpr(left BooleanValue, right BooleanValue, operator string) RuntimeValue {
-	result := false
-	leftVal, rightVal := left.GetValue(), right.GetValue()
-
-	if operator == "==" {
-		result = leftVal == rightVal
-	} else if operator == "!=" {
-		result = leftVal != rightVal
-	} else if operator == "&&" {
-		result = leftVal && rightVal
-	} else if operator == "||" {
-		result = leftVal || rightVal
+	result, err := CompareOp(expr.Operator, left, right)
+	if err != nil {
+		raiseError(scope, expr, err)
 	}
-
-	return MakeBoolean(result)
+	return result
 }
 
-func evalNumericComparisonExpr(left NumberValue, right NumberValue, operator string) RuntimeValue {
-	result := false
+// Evaluates && and || with short-circuiting. Like JavaScript, the result is whichever operand decided
+// the outcome rather than a boolean, so name || "default" works. See IsTruthy for what counts as true
+func evalLogicalExpr(expr parser.LogicalExpr, scope *Scope) RuntimeValue {
+	left := Evaluate(expr.Left, scope)
 
-	if operator == "==" {
-		result = left.Value == right.Value
-	} else if operator == "!=" {
-		result = left.Value != right.Value
-	} else if operator == ">=" {
-		result = left.Value >= right.Value
-	} else if operator == "<=" {
-		result = left.Value <= right.Value
-	} else if operator == ">" {
-		result = left.Value > right.Value
-	} else if operator == "<" {
-		result = left.Value < right.Value
+	if expr.Operator == "&&" {
+		if !IsTruthy(left) {
+			return left
+		}
+	} else if IsTruthy(left) { // ||
+		return left
 	}
 
-	return MakeBoolean(result)
+	return Evaluate(expr.Right, scope)
 }
@@ -2,18 +2,69 @@ package runtime
 
 import (
 	"QuonkScript/parser"
+	"context"
 )
 
+// Evaluates astNode like Evaluate, but errors raised by the program are returned as a *RuntimeError rather than
+// unwinding the Go stack. Panics that are not RuntimeErrors are interpreter bugs and are not recovered
+func Run(astNode parser.Stmt, scope *Scope) (RuntimeValue, error) {
+	return RunContext(context.Background(), astNode, scope)
+}
+
+// Like Run, but the program is stopped with an error wrapping ErrCancelled once ctx is done.
+// The Limits of the scope's State are applied as well
+func RunContext(ctx context.Context, astNode parser.Stmt, scope *Scope) (result RuntimeValue, err error) {
+	state := scope.State()
+	defer state.StartBudget(ctx)()
+	defer catchRuntimeError(state, len(state.Frames), &result, &err)
+
+	return Evaluate(astNode, scope), nil
+}
+
+// Calls a QuonkScript or native function from Go with already evaluated arguments.
+// Errors are returned the same way as Run. Natives should use Scope.CallFunction instead
+func Call(fn RuntimeValue, args []RuntimeValue, scope *Scope) (RuntimeValue, error) {
+	return CallContext(context.Background(), fn, args, scope)
+}
+
+// Like Call, but stopped once ctx is done the same way as RunContext
+func CallContext(ctx context.Context, fn RuntimeValue, args []RuntimeValue, scope *Scope) (result RuntimeValue, err error) {
+	state := scope.State()
+	defer state.StartBudget(ctx)()
+	defer catchRuntimeError(state, len(state.Frames), &result, &err)
+
+	return callFunction(fn, args, scope, nil), nil
+}
+
+// Deferred by Run and Call to turn a raised RuntimeError into their error result
+func catchRuntimeError(state *State, frames int, result *RuntimeValue, err *error) {
+	if r := recover(); r != nil {
+		runtimeErr, ok := r.(*RuntimeError)
+		if !ok {
+			panic(r)
+		}
+		// Drop the frames of the calls that were aborted
+		state.Frames = state.Frames[:frames]
+		*result, *err = MakeNull(), runtimeErr
+	}
+}
+
 // Typecasts used in ths function should be safe since we are careful about how we assign node types
 func Evaluate(astNode parser.Stmt, scope *Scope) RuntimeValue {
+	scope.State().step(scope, astNode)
+
 	switch astNode.GetKind() {
 	case parser.NumericLiteralNode:
 		return MakeNumber(astNode.(parser.NumericLiteral).Value)
+	case parser.IntegerLiteralNode:
+		return MakeInteger(astNode.(parser.IntegerLiteral).Value)
 	// Return a null by default
 	case parser.NullLiteralNode:
 		return MakeNull()
 	case parser.BooleanLiteralNode:
 		return MakeBoolean(astNode.(parser.BooleanLiteral).Value)
+	case parser.StringLiteralNode:
+		return MakeString(astNode.(parser.StringLiteral).Value)
 	case parser.BinaryExprNode:
 		return evalBinaryExpr(astNode.(parser.BinaryExpr), scope)
 	case parser.IdentifierNode:
@@ -27,14 +78,34 @@ func Evaluate(astNode parser.Stmt, scope *Scope) RuntimeValue {
 		return evalAssignmentExpr(astNode.(parser.VarAssignmentExpr), scope)
 	case parser.ObjectLiteralNode:
 		return evalObjectExpr(astNode.(parser.ObjectLiteral), scope)
+	case parser.ArrayLiteralNode:
+		return evalArrayExpr(astNode.(parser.ArrayLiteral), scope)
+	case parser.MemberExprNode:
+		return evalMemberExpr(astNode.(parser.MemberExpr), scope)
 	case parser.InternalFunctionCallExprNode:
 		return evalCallExpr(astNode.(parser.InternalFunctionCallExpr), scope)
 	case parser.ComparisonExprNode:
 		return evalComparisonExpr(astNode.(parser.ComparisonExpr), scope)
+	case parser.UnaryExprNode:
+		return evalUnaryExpr(astNode.(parser.UnaryExpr), scope)
+	case parser.LogicalExprNode:
+		return evalLogicalExpr(astNode.(parser.LogicalExpr), scope)
 	case parser.FunctionDeclarationNode:
 		return evalFunctionDeclaration(astNode.(parser.FunctionDeclaration), scope)
+	case parser.FunctionExprNode:
+		return evalFunctionExpr(astNode.(parser.FunctionExpr), scope)
 	case parser.BranchNode:
 		return evalBranchStatement(astNode.(parser.BranchStmt), scope)
+	case parser.ReturnNode:
+		return evalReturnStmt(astNode.(parser.ReturnStmt), scope)
+	case parser.WhileNode:
+		return evalWhileStmt(astNode.(parser.WhileStmt), scope)
+	case parser.ForNode:
+		return evalForStmt(astNode.(parser.ForStmt), scope)
+	case parser.BreakNode:
+		return evalBreakStmt(astNode.(parser.BreakStmt), scope)
+	case parser.ContinueNode:
+		return evalContinueStmt(astNode.(parser.ContinueStmt), scope)
 	default:
 		parser.PrintAST(astNode)
 		panic("This NodeType has not been implemented")
@@ -0,0 +1,356 @@
+package runtime
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+)
+
+// Operators work on values rather than AST nodes so that the vm package evaluates them exactly the same way
+// as Evaluate does. Errors are returned for the caller to raise at the node they belong to
+
+// Applies an arithmetic operator, + concatenates when either side is a string.
+// Two integers give an integer, an integer and a float give a float
+func BinaryOp(operator string, left RuntimeValue, right RuntimeValue) (RuntimeValue, error) {
+	if left.GetType() == IntegerValueType && right.GetType() == IntegerValueType {
+		return integerBinaryOp(left.(IntegerValue).Value, right.(IntegerValue).Value, operator)
+	} else if IsNumber(left) && IsNumber(right) {
+		leftVal, _ := ToFloat(left)
+		rightVal, _ := ToFloat(right)
+		return floatBinaryOp(leftVal, rightVal, operator), nil
+	} else if left.GetType() == StringValueType || right.GetType() == StringValueType {
+		return stringBinaryOp(left, right, operator)
+	} else {
+		return MakeNull(), nil
+	}
+}
+
+// Results that do not fit in an integer are an error instead of wrapping around. / truncates towards zero
+// and % takes the sign of the left operand, so that (a / b) * b + a % b == a
+func integerBinaryOp(left int64, right int64, operator string) (RuntimeValue, error) {
+	var num int64
+	overflow := false
+
+	if operator == "+" {
+		num = left + right
+		overflow = (num > left) != (right > 0)
+	} else if operator == "-" {
+		num = left - right
+		overflow = (num < left) != (right > 0)
+	} else if operator == "*" {
+		num = left * right
+		overflow = left != 0 && (num/left != right || (left == -1 && right == math.MinInt64))
+	} else if operator == "/" || operator == "%" {
+		if right == 0 {
+			return nil, fmt.Errorf("Integer division by zero in %d %s %d", left, operator, right)
+		}
+		if operator == "/" {
+			num = left / right
+			overflow = left == math.MinInt64 && right == -1
+		} else {
+			num = left % right
+		}
+	}
+
+	if overflow {
+		return nil, fmt.Errorf("Integer overflow in %d %s %d", left, operator, right)
+	}
+	return MakeInteger(num), nil
+}
+
+// Floats follow IEEE 754, so dividing by zero gives an infinity rather than an error
+func floatBinaryOp(left float64, right float64, operator string) RuntimeValue {
+	num := 0.0
+
+	if operator == "+" {
+		num = left + right
+	} else if operator == "-" {
+		num = left - right
+	} else if operator == "*" {
+		num = left * right
+	} else if operator == "/" {
+		num = left / right
+	} else if operator == "%" {
+		// Truncated like integer %
+		num = math.Mod(left, right)
+	}
+
+	return MakeNumber(num)
+}
+
+// Strings only support +, which concatenates. The other side is converted to a string the same way print would,
+// so an array or object that contains itself is shown as [...] or {...} inside of itself
+func stringBinaryOp(left RuntimeValue, right RuntimeValue, operator string) (RuntimeValue, error) {
+	if operator != "+" {
+		return nil, fmt.Errorf("Operator %s cannot be applied to strings", operator)
+	}
+
+	return MakeString(printRuntimeValue(left) + printRuntimeValue(right)), nil
+}
+
+func UnaryOp(operator string, operand RuntimeValue) (RuntimeValue, error) {
+	switch operator {
+	case "-":
+		if operand.GetType() == NumberValueType {
+			return MakeNumber(-operand.(NumberValue).Value), nil
+		}
+		if operand.GetType() == IntegerValueType {
+			num := operand.(IntegerValue).Value
+			if num == math.MinInt64 {
+				return nil, fmt.Errorf("Integer overflow in -(%d)", num)
+			}
+			return MakeInteger(-num), nil
+		}
+	case "+":
+		if IsNumber(operand) {
+			return operand, nil
+		}
+	case "!":
+		return MakeBoolean(!IsTruthy(operand)), nil
+	}
+
+	return nil, fmt.Errorf("Operator %s cannot be applied to %s", operator, operand.GetType())
+}
+
+func CompareOp(operator string, left RuntimeValue, right RuntimeValue) (RuntimeValue, error) {
+	isEquality := operator == "==" || operator == "!="
+
+	if left.GetType() == BooleanValueType && right.GetType() == BooleanValueType {
+		return booleanComparison(left.(BooleanValue), right.(BooleanValue), operator), nil
+	} else if left.GetType() == IntegerValueType && right.GetType() == IntegerValueType {
+		return numericComparison(left.(IntegerValue).Value, right.(IntegerValue).Value, operator), nil
+	} else if left.GetType() == NumberValueType && right.GetType() == NumberValueType {
+		return numericComparison(left.(NumberValue).Value, right.(NumberValue).Value, operator), nil
+	} else if IsNumber(left) && IsNumber(right) {
+		// Integers are compared with floats by value, so 1 == 1.0
+		var order int
+		var ok bool
+		if integer, isInteger := left.(IntegerValue); isInteger {
+			order, ok = CompareIntegerFloat(integer.Value, right.(NumberValue).Value)
+		} else {
+			order, ok = CompareIntegerFloat(right.(IntegerValue).Value, left.(NumberValue).Value)
+			order = -order
+		}
+		if !ok {
+			// NaN is only unequal
+			return MakeBoolean(operator == "!="), nil
+		}
+		return numericComparison(int64(order), 0, operator), nil
+	} else if left.GetType() == StringValueType && right.GetType() == StringValueType {
+		return stringComparison(left.(StringValue), right.(StringValue), operator), nil
+	} else if isEquality {
+		// Everything else is only equal to itself, and values of different types are never equal
+		equal := isSameValue(left, right)
+		return MakeBoolean(equal == (operator == "==")), nil
+	} else {
+		return nil, fmt.Errorf("Operator %s cannot compare %s with %s", operator, left.GetType(), right.GetType())
+	}
+}
+
+// Compares an integer with a float exactly. Converting the integer to a float would round integers above 2^53, which
+// would make 9007199254740993 == 9007199254740992.0. Returns -1, 0 or 1 as i is smaller, equal or larger, and false
+// if f is NaN, which is none of those
+func CompareIntegerFloat(i int64, f float64) (int, bool) {
+	switch {
+	case math.IsNaN(f):
+		return 0, false
+	case f >= math.MaxInt64: // the float closest to MaxInt64 is 2^63, which is out of range
+		return -1, true
+	case f < math.MinInt64:
+		return 1, true
+	}
+
+	// f is in range, so its floor converts exactly. i equal to the floor is still smaller if f has a fraction
+	floor := math.Floor(f)
+	switch {
+	case i < int64(floor):
+		return -1, true
+	case i > int64(floor):
+		return 1, true
+	case f > floor:
+		return -1, true
+	}
+	return 0, true
+}
+
+// Checks whether two values are the same null, object, array or function
+func isSameValue(left RuntimeValue, right RuntimeValue) bool {
+	if left.GetType() != right.GetType() {
+		return false
+	}
+
+	switch left.GetType() {
+	case NullValueType:
+		return true
+	case ObjectValueType:
+		return left.(ObjectValue).Properties == right.(ObjectValue).Properties
+	case ArrayValueType:
+		return left.(ArrayValue).Elements == right.(ArrayValue).Elements
+	case FunctionValueType:
+		// Same declaration closing over the same scope
+		leftFn, rightFn := left.(FunctionValue), right.(FunctionValue)
+		sameBody := reflect.ValueOf(leftFn.Body).Pointer() == reflect.ValueOf(rightFn.Body).Pointer()
+		return sameBody && leftFn.Name == rightFn.Name && leftFn.DeclarationScope == rightFn.DeclarationScope
+	case InternalFunctionValueType:
+		return reflect.ValueOf(left.(InternalFunctionValue).Func).Pointer() == reflect.ValueOf(right.(InternalFunctionValue).Func).Pointer()
+	}
+	return false
+}
+
+func booleanComparison(left BooleanValue, right BooleanValue, operator string) RuntimeValue {
+	result := false
+	leftVal, rightVal := left.GetValue(), right.GetValue()
+
+	if operator == "==" {
+		result = leftVal == rightVal
+	} else if operator == "!=" {
+		result = leftVal != rightVal
+	}
+
+	return MakeBoolean(result)
+}
+
+func numericComparison[T int64 | float64](left T, right T, operator string) RuntimeValue {
+	result := false
+
+	if operator == "==" {
+		result = left == right
+	} else if operator == "!=" {
+		result = left != right
+	} else if operator == ">=" {
+		result = left >= right
+	} else if operator == "<=" {
+		result = left <= right
+	} else if operator == ">" {
+		result = left > right
+	} else if operator == "<" {
+		result = left < right
+	}
+
+	return MakeBoolean(result)
+}
+
+// Strings are ordered byte-wise, the same way Go orders them
+func stringComparison(left StringValue, right StringValue, operator string) RuntimeValue {
+	result := false
+
+	if operator == "==" {
+		result = left.Value == right.Value
+	} else if operator == "!=" {
+		result = left.Value != right.Value
+	} else if operator == ">=" {
+		result = left.Value >= right.Value
+	} else if operator == "<=" {
+		result = left.Value <= right.Value
+	} else if operator == ">" {
+		result = left.Value > right.Value
+	} else if operator == "<" {
+		result = left.Value < right.Value
+	}
+
+	return MakeBoolean(result)
+}
+
+// Members
+
+// Checks that obj has members before its key is evaluated. assign chooses the error message for obj.key = value
+func CheckMemberTarget(obj RuntimeValue, assign bool) error {
+	if obj.GetType() == ArrayValueType || obj.GetType() == ObjectValueType {
+		return nil
+	}
+	if assign {
+		return fmt.Errorf("Cannot assign to member of %s value", obj.GetType())
+	}
+	return fmt.Errorf("Cannot access member of %s value", obj.GetType())
+}
+
+// Checks key for obj[key], or obj.key when computed is false and key is the name after the dot.
+// Array indexes must be integers or whole floats in bounds and computed property names must be strings
+func CheckMemberKey(obj RuntimeValue, key RuntimeValue, computed bool) error {
+	if arr, ok := obj.(ArrayValue); ok {
+		_, err := arrayIndex(arr, key, computed)
+		return err
+	}
+	_, err := propertyKey(key)
+	return err
+}
+
+// Reads obj[key] or obj.key, missing properties read as null
+func GetMember(obj RuntimeValue, key RuntimeValue, computed bool) (RuntimeValue, error) {
+	switch obj := obj.(type) {
+	case ArrayValue:
+		index, err := arrayIndex(obj, key, computed)
+		if err != nil {
+			return nil, err
+		}
+		return obj.Get(index), nil
+	case ObjectValue:
+		name, err := propertyKey(key)
+		if err != nil {
+			return nil, err
+		}
+		if value := obj.Get(name); value != nil {
+			return value, nil
+		}
+		return MakeNull(), nil
+	}
+	return nil, CheckMemberTarget(obj, false)
+}
+
+// Assigns to obj[key] or obj.key and returns value
+func SetMember(obj RuntimeValue, key RuntimeValue, computed bool, value RuntimeValue) (RuntimeValue, error) {
+	switch obj := obj.(type) {
+	case ArrayValue:
+		index, err := arrayIndex(obj, key, computed)
+		if err != nil {
+			return nil, err
+		}
+		return obj.Set(index, value), nil
+	case ObjectValue:
+		name, err := propertyKey(key)
+		if err != nil {
+			return nil, err
+		}
+		return obj.Set(name, value), nil
+	}
+	return nil, CheckMemberTarget(obj, true)
+}
+
+// Property names must be strings
+func propertyKey(key RuntimeValue) (string, error) {
+	if key.GetType() != StringValueType {
+		return "", fmt.Errorf("Object property name must be a string, got %s", key.GetType())
+	}
+	return key.(StringValue).GetValue(), nil
+}
+
+// Checks that key is an index in bounds of arr
+func arrayIndex(arr ArrayValue, key RuntimeValue, computed bool) (int, error) {
+	if !computed {
+		return 0, fmt.Errorf("Arrays can only be indexed with [], not .")
+	}
+
+	var index int64
+	switch key := key.(type) {
+	case IntegerValue:
+		index = key.Value
+	case NumberValue:
+		// Whole floats, like the results of math.floor, can index arrays too
+		if key.Value != math.Trunc(key.Value) {
+			return 0, fmt.Errorf("Array index must be a whole number, got %v", key.Value)
+		}
+		// Clamped so that indexes too large for an integer are still out of range
+		index = int64(math.Max(-1, math.Min(key.Value, float64(arr.Len()))))
+	default:
+		return 0, fmt.Errorf("Array index must be a number, got %s", key.GetType())
+	}
+
+	if index < 0 {
+		return 0, fmt.Errorf("Negative array index %s", printRuntimeValue(key))
+	}
+	if index >= int64(arr.Len()) {
+		return 0, fmt.Errorf("Array index %s out of range for array of length %d", printRuntimeValue(key), arr.Len())
+	}
+
+	return int(index), nil
+}
@@ -0,0 +1,65 @@
+package runtime
+
+import (
+	"math"
+	"testing"
+)
+
+func TestCompareIntegerFloat(t *testing.T) {
+	for _, test := range []struct {
+		i     int64
+		f     float64
+		order int
+		ok    bool
+	}{
+		{1, 1.0, 0, true},
+		{1, 1.5, -1, true},
+		{2, 1.5, 1, true},
+		{-2, -1.5, -1, true},
+		{-1, -1.5, 1, true},
+		{1 << 53, 1 << 53, 0, true},
+		{1<<53 + 1, 1 << 53, 1, true},
+		{1<<53 - 1, 1 << 53, -1, true},
+		{math.MaxInt64, 1 << 63, -1, true},
+		{math.MinInt64, -(1 << 63), 0, true},
+		{math.MinInt64, math.Nextafter(-(1 << 63), math.Inf(-1)), 1, true},
+		{0, math.Inf(1), -1, true},
+		{0, math.Inf(-1), 1, true},
+		{0, math.NaN(), 0, false},
+	} {
+		order, ok := CompareIntegerFloat(test.i, test.f)
+		if order != test.order || ok != test.ok {
+			t.Errorf("CompareIntegerFloat(%d, %v) = %d, %t, want %d, %t", test.i, test.f, order, ok, test.order, test.ok)
+		}
+	}
+}
+
+func TestCompareOpMixed(t *testing.T) {
+	big, rounded := MakeInteger(1<<53+1), MakeNumber(1<<53)
+	nan := MakeNumber(math.NaN())
+	for _, test := range []struct {
+		operator    string
+		left, right RuntimeValue
+		want        bool
+	}{
+		{"==", big, rounded, false},
+		{"!=", big, rounded, true},
+		{">", big, rounded, true},
+		{"<", rounded, big, true},
+		{">=", rounded, big, false},
+		{"==", MakeInteger(3), MakeNumber(3), true},
+		{"<=", MakeNumber(3), MakeInteger(3), true},
+		{"==", MakeInteger(0), nan, false},
+		{"<", nan, MakeInteger(0), false},
+		{">=", MakeInteger(0), nan, false},
+		{"!=", nan, MakeInteger(0), true},
+	} {
+		result, err := CompareOp(test.operator, test.left, test.right)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.(BooleanValue).Value != test.want {
+			t.Errorf("%s %s %s is %t, want %t", formatValue(test.left, nil), test.operator, formatValue(test.right, nil), !test.want, test.want)
+		}
+	}
+}
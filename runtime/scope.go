@@ -1,56 +1,200 @@
 package runtime
 
 import (
+	"QuonkScript/lexer"
 	"fmt"
+	"io"
 )
 
 type Scope struct {
 	Parent    *Scope              // pointer to env so it can be null
 	Variables map[string]Variable // To restore this functionality to what is in the guide, this should be map[string]RuntimeValue. See: https://www.youtube.com/watch?v=isKQ3CS5s0s&list=PL_2VhOvlMk4UHGqYCLWc6GO8FaPl8fQTh&index=6
+	Slots     []RuntimeValue      // variables bound by the resolver, by slot. nil until declared
+	state     *State              // shared by every scope of the same program, found lazily through Parent if nil
 }
 
-func (s *Scope) DeclareVariable(varname string, value RuntimeValue, constant bool) RuntimeValue {
+// State of a running program that is shared by all of its scopes
+type State struct {
+	Frames []Frame   // QuonkScript function calls currently being evaluated, most recent call last
+	Stdout io.Writer // where print writes to, os.Stdout if nil
+	Limits Limits    // applied to every Run and Call
+	budget budget
+}
+
+// Creates a scope that inherits from parent. A nil parent creates a new global scope with its own State.
+// Variables is only made once something is declared by name, local scopes of resolved programs only use Slots
+func NewScope(parent *Scope) *Scope {
+	scope := &Scope{Parent: parent}
+	if parent != nil {
+		scope.state = parent.State()
+	} else {
+		scope.state = &State{}
+	}
+	return scope
+}
+
+func (s *Scope) State() *State {
+	if s.state == nil {
+		if s.Parent == nil {
+			s.state = &State{}
+		} else {
+			s.state = s.Parent.State()
+		}
+	}
+	return s.state
+}
+
+func (s *Scope) DeclareVariable(varname string, value RuntimeValue, constant bool) (RuntimeValue, error) {
 	if s.Variables[varname] != nil {
-		panic(fmt.Sprintf("Honk! Cannot redeclare variable %s", varname))
+		return MakeNull(), fmt.Errorf("Cannot redeclare variable %s", varname)
+	}
+	if s.Variables == nil {
+		s.Variables = make(map[string]Variable)
 	}
 
 	s.Variables[varname] = VariableValue{Value: &value, Constant: constant, Name: varname}
 
-	return value
+	return value, nil
 }
 
-func (e *Scope) AssignVariable(varname string, value RuntimeValue) RuntimeValue {
-	scope := e.Resolve(varname)
+func (e *Scope) AssignVariable(varname string, value RuntimeValue) (RuntimeValue, error) {
+	scope, err := e.Resolve(varname)
+	if err != nil {
+		return MakeNull(), err
+	}
 
 	variable := scope.Variables[varname]
 
 	if variable != nil && variable.IsConstant() {
-		panic(fmt.Sprintf("Honk! Cannot assign to constant variable %s", varname))
+		return MakeNull(), fmt.Errorf("Cannot assign to constant variable %s", varname)
 	}
 
-	// If we make it past the panic, we know the variable will not be constant
+	// If we make it past the check, we know the variable will not be constant
 	scope.Variables[varname] = VariableValue{Value: &value, Name: varname, Constant: false}
-	return value
+	return value, nil
 }
 
-func (e *Scope) LookupVariable(varname string) RuntimeValue {
-	scope := e.Resolve(varname)
-	return scope.Variables[varname].GetValue()
+func (e *Scope) LookupVariable(varname string) (RuntimeValue, error) {
+	scope, err := e.Resolve(varname)
+	if err != nil {
+		return MakeNull(), err
+	}
+	return scope.Variables[varname].GetValue(), nil
 }
 
-func (s *Scope) Resolve(varname string) *Scope {
+func (s *Scope) Resolve(varname string) (*Scope, error) {
 	if s.Variables[varname] != nil {
-		return s
+		return s, nil
 	}
 
 	if s.Parent == nil {
-		panic(fmt.Sprintf("Honk! Cannot resolve variable %s", varname))
+		return nil, fmt.Errorf("Cannot resolve variable %s", varname)
 	}
 
 	// since Parent is a pointer to allow for nil, Scope will always be a pointer
 	return s.Parent.Resolve(varname)
 }
 
+// Declares the variable the resolver bound to slot in this scope
+func (s *Scope) DeclareSlot(slot int, value RuntimeValue) RuntimeValue {
+	for len(s.Slots) <= slot {
+		s.Slots = append(s.Slots, nil)
+	}
+	s.Slots[slot] = value
+	return value
+}
+
+// Reads slot of the scope depth levels up. name is only used for the error, which happens when a function reads
+// a variable of an enclosing scope that has not been declared yet
+func (s *Scope) LookupSlot(depth int, slot int, name string) (RuntimeValue, error) {
+	scope := s.ancestor(depth)
+	if slot >= len(scope.Slots) || scope.Slots[slot] == nil {
+		return MakeNull(), fmt.Errorf("Cannot use variable %s before it is declared", name)
+	}
+	return scope.Slots[slot], nil
+}
+
+// Assigns to slot of the scope depth levels up, the resolver has already checked that it is not a constant
+func (s *Scope) AssignSlot(depth int, slot int, name string, value RuntimeValue) (RuntimeValue, error) {
+	scope := s.ancestor(depth)
+	if slot >= len(scope.Slots) || scope.Slots[slot] == nil {
+		return MakeNull(), fmt.Errorf("Cannot use variable %s before it is declared", name)
+	}
+	scope.Slots[slot] = value
+	return value, nil
+}
+
+// Starts the next iteration of a for loop whose header declared its variables in s. Returns a scope holding copies
+// of them, so that closures made during an iteration keep the values of that iteration
+func (s *Scope) NextIteration() *Scope {
+	next := &Scope{Parent: s.Parent, state: s.state}
+	if s.Variables != nil {
+		next.Variables = make(map[string]Variable, len(s.Variables))
+		for name, variable := range s.Variables {
+			value := variable.GetValue()
+			next.Variables[name] = VariableValue{Value: &value, Constant: variable.IsConstant(), Name: name}
+		}
+	}
+	if s.Slots != nil {
+		next.Slots = make([]RuntimeValue, len(s.Slots), cap(s.Slots))
+		copy(next.Slots, s.Slots)
+	}
+	return next
+}
+
+func (s *Scope) ancestor(depth int) *Scope {
+	scope := s
+	for i := 0; i < depth; i++ {
+		scope = scope.Parent
+	}
+	return scope
+}
+
+// Calls fn with args, for natives that take functions as arguments. It can be called while the program is running,
+// errors raised by fn are returned as a *RuntimeError that the native can return to have it raised in the calling program
+// with its original position and stack
+func (s *Scope) CallFunction(fn RuntimeValue, args []RuntimeValue) (RuntimeValue, error) {
+	result, err := Call(fn, args, s)
+
+	// Errors raised before fn starts running, such as a wrong number of arguments or too deep a call stack, have no
+	// position since the call did not come from the source. Locate them where the native making the call was called
+	state := s.State()
+	if runtimeErr, ok := err.(*RuntimeError); ok && runtimeErr.Pos == (lexer.Position{}) && len(state.Frames) > 0 {
+		if native := state.Frames[len(state.Frames)-1]; native.Function == NativeFunctionName {
+			runtimeErr.Pos = native.CallSite
+		}
+	}
+	return result, err
+}
+
+// Sets up a call to a QuonkScript function, for both the tree-walker and the vm. Checks the arguments and, unless the
+// call replaces one that is finishing, the call depth. Then pushes the call's frame and returns the scope the body
+// runs in, which inherits from the scope the function was declared in
+func (s *State) EnterCall(function FunctionValue, args []RuntimeValue, callSite lexer.Position, tail bool) (*Scope, error) {
+	if len(args) != len(function.Params) {
+		return nil, fmt.Errorf("Function %s expects %d arguments but was called with %d", function.Name, len(function.Params), len(args))
+	}
+	if !tail {
+		if err := s.CheckCallDepth(function.Name); err != nil {
+			return nil, err
+		}
+	}
+
+	scope := NewScope(function.DeclarationScope)
+	if function.Slots > 0 {
+		// Parameters are the first slots
+		scope.Slots = make([]RuntimeValue, len(args), function.Slots)
+		copy(scope.Slots, args)
+	} else {
+		for i, param := range function.Params {
+			scope.DeclareVariable(param, args[i], false)
+		}
+	}
+
+	s.Frames = append(s.Frames, Frame{Function: function.Name, CallSite: callSite})
+	return scope, nil
+}
+
 // Takes in pointer to scope and mutates it to hold global variables
 func SetupScope(scope *Scope) {
 	scope.DeclareVariable("true", MakeBoolean(true), true)
@@ -58,4 +202,13 @@ func SetupScope(scope *Scope) {
 
 	// define native functions
 	scope.DeclareVariable("print", MakeFunction(Print), true)
+	scope.DeclareVariable("len", MakeFunction(Len), true)
+	scope.DeclareVariable("keys", MakeFunction(Keys), true)
+	scope.DeclareVariable("push", MakeFunction(Push), true)
+	scope.DeclareVariable("map", MakeFunction(Map), true)
+	scope.DeclareVariable("filter", MakeFunction(Filter), true)
+	scope.DeclareVariable("forEach", MakeFunction(ForEach), true)
+	scope.DeclareVariable("int", MakeFunction(Int), true)
+	scope.DeclareVariable("float", MakeFunction(Float), true)
+	scope.DeclareVariable("math", makeMathObject(), true)
 }
@@ -2,11 +2,111 @@ package runtime
 
 import "QuonkScript/parser"
 
-func evalProgram(prog parser.Program, scope *Scope) RuntimeValue {
+// Produced by a return statement in place of a value. Statement bodies stop evaluating when they see one
+// and pass it up until it reaches the function call, which unwraps it into the call's result
+type returnSignal struct {
+	Value RuntimeValue
+	Stmt  parser.ReturnStmt
+}
+
+func (r returnSignal) GetType() ValueType {
+	return ControlValueType
+}
+
+// Produced by break, handled by the enclosing loop
+type breakSignal struct {
+	Stmt parser.BreakStmt
+}
+
+func (b breakSignal) GetType() ValueType {
+	return ControlValueType
+}
+
+// Produced by continue, handled by the enclosing loop
+type continueSignal struct {
+	Stmt parser.ContinueStmt
+}
+
+func (c continueSignal) GetType() ValueType {
+	return ControlValueType
+}
+
+// Produced in place of a call in tail position, which is a return operand or the last statement of a function body.
+// The function returns it to callFunction, which then makes the call in place of the one that is finishing,
+// so that tail recursive functions run in constant Go stack space
+type tailCallSignal struct {
+	Fn   RuntimeValue
+	Args []RuntimeValue
+	Call parser.InternalFunctionCallExpr
+}
+
+func (t tailCallSignal) GetType() ValueType {
+	return ControlValueType
+}
+
+// Raises an error for a control signal that escaped the statement it belongs to.
+// The parser already rejects these, but the AST may not have come from the parser
+func raiseStrayControl(scope *Scope, signal RuntimeValue) {
+	switch signal := signal.(type) {
+	case returnSignal:
+		raise(scope, signal.Stmt, "Cannot return outside of a function")
+	case breakSignal:
+		raise(scope, signal.Stmt, "Cannot break outside of a loop")
+	case continueSignal:
+		raise(scope, signal.Stmt, "Cannot continue outside of a loop")
+	}
+}
+
+// Evaluates the condition of an if statement or loop, any value can be a condition, see IsTruthy
+func evalCondition(condition parser.Expr, scope *Scope) bool {
+	return IsTruthy(Evaluate(condition, scope))
+}
+
+// Evaluates a list of statements, stopping early and passing on any control signal
+func evalBody(body []parser.Stmt, scope *Scope) RuntimeValue {
 	var lastEvaluated RuntimeValue = MakeNull()
 
-	for _, stmt := range prog.Body {
+	for _, stmt := range body {
 		lastEvaluated = Evaluate(stmt, scope)
+		if lastEvaluated.GetType() == ControlValueType {
+			break
+		}
+	}
+
+	return lastEvaluated
+}
+
+// Evaluates the body of a function like evalBody, except that the last statement is in tail position
+func evalFunctionBody(body []parser.Stmt, scope *Scope) RuntimeValue {
+	if len(body) == 0 {
+		return MakeNull()
+	}
+
+	result := evalBody(body[:len(body)-1], scope)
+	if result.GetType() == ControlValueType {
+		return result
+	}
+	return evalTailPosition(body[len(body)-1], scope)
+}
+
+// Evaluates a statement in tail position, a call is not made but returned as a tailCallSignal
+func evalTailPosition(stmt parser.Stmt, scope *Scope) RuntimeValue {
+	call, ok := stmt.(parser.InternalFunctionCallExpr)
+	if !ok {
+		return Evaluate(stmt, scope)
+	}
+
+	// Counted the same as if Evaluate was called on it
+	scope.State().step(scope, call)
+	fn, args := evalCallee(call, scope)
+	return tailCallSignal{Fn: fn, Args: args, Call: call}
+}
+
+func evalProgram(prog parser.Program, scope *Scope) RuntimeValue {
+	lastEvaluated := evalBody(prog.Body, scope)
+
+	if lastEvaluated.GetType() == ControlValueType {
+		raiseStrayControl(scope, lastEvaluated)
 	}
 
 	return lastEvaluated
@@ -21,33 +121,118 @@ func evalVarDeclaration(declaration parser.VarDeclaration, scope *Scope) Runtime
 		value = Evaluate(*declaration.Value, scope)
 	}
 
-	return scope.DeclareVariable(declaration.Identifier, value, declaration.Constant)
+	value = NameFunction(value, declaration.Identifier)
+	if declaration.Binding.Local {
+		return scope.DeclareSlot(declaration.Binding.Slot, value)
+	}
+
+	value, err := scope.DeclareVariable(declaration.Identifier, value, declaration.Constant)
+	if err != nil {
+		raiseError(scope, declaration, err)
+	}
+	return value
 }
 
 func evalBranchStatement(stmt parser.BranchStmt, scope *Scope) RuntimeValue {
 	var lastEvaluated RuntimeValue = MakeNull()
 
-	childScope := &Scope{Parent: scope, Variables: map[string]Variable{}}
+	childScope := NewScope(scope)
 
-	condition := Evaluate(stmt.Condition, scope).(BooleanValue)
+	if evalCondition(stmt.Condition, scope) {
+		lastEvaluated = evalBody(stmt.Body, childScope)
+	} else {
+		lastEvaluated = evalBody(stmt.Else, childScope)
+	}
 
-	if condition.Type == BooleanValueType {
-		if condition.GetValue() {
-			for _, bodyStmt := range stmt.Body {
-				lastEvaluated = Evaluate(bodyStmt, childScope)
-			}
-		} else {
-			for _, elseStmt := range stmt.Else {
-				lastEvaluated = Evaluate(elseStmt, childScope)
+	return lastEvaluated
+}
+
+func evalFunctionDeclaration(declaration parser.FunctionDeclaration, scope *Scope) RuntimeValue {
+	function := FunctionValue{Name: declaration.Name, Params: declaration.Params, DeclarationScope: scope, Body: declaration.Body, Slots: declaration.Slots, TypedValue: TypedValue{Type: FunctionValueType}} // intializes with zero value for all fields
+	if declaration.Binding.Local {
+		return scope.DeclareSlot(declaration.Binding.Slot, function)
+	}
+
+	value, err := scope.DeclareVariable(function.Name, function, true)
+	if err != nil {
+		raiseError(scope, declaration, err)
+	}
+	return value
+}
+
+// Anonymous functions capture the scope they are created in, the same way declared functions do
+func evalFunctionExpr(expr parser.FunctionExpr, scope *Scope) RuntimeValue {
+	return FunctionValue{Name: AnonymousFunctionName, Params: expr.Params, DeclarationScope: scope, Body: expr.Body, Slots: expr.Slots, TypedValue: TypedValue{Type: FunctionValueType}}
+}
+
+func evalReturnStmt(stmt parser.ReturnStmt, scope *Scope) RuntimeValue {
+	var value RuntimeValue = MakeNull()
+
+	if stmt.Value != nil {
+		value = evalTailPosition(*stmt.Value, scope)
+	}
+
+	return returnSignal{Value: value, Stmt: stmt}
+}
+
+func evalBreakStmt(stmt parser.BreakStmt, scope *Scope) RuntimeValue {
+	return breakSignal{Stmt: stmt}
+}
+
+func evalContinueStmt(stmt parser.ContinueStmt, scope *Scope) RuntimeValue {
+	return continueSignal{Stmt: stmt}
+}
+
+// Evaluates one iteration of a loop body in a fresh scope. Returns whether the loop should
+// keep going, and the signal to pass on if a return is leaving the loop
+func evalLoopBody(body []parser.Stmt, scope *Scope) (bool, RuntimeValue) {
+	result := evalBody(body, NewScope(scope))
+
+	switch result.(type) {
+	case breakSignal:
+		return false, nil
+	case returnSignal:
+		return false, result
+	}
+	// continue only ends the iteration early, which evalBody has already done
+	return true, nil
+}
+
+// Loops evaluate to null unless a return leaves them
+func evalWhileStmt(stmt parser.WhileStmt, scope *Scope) RuntimeValue {
+	for evalCondition(stmt.Condition, scope) {
+		if keepGoing, signal := evalLoopBody(stmt.Body, scope); !keepGoing {
+			if signal != nil {
+				return signal
 			}
+			break
 		}
 	}
 
-	return lastEvaluated
+	return MakeNull()
 }
 
-func evalFunctionDeclaration(declaration parser.FunctionDeclaration, scope *Scope) RuntimeValue {
-	function := FunctionValue{Name: declaration.Name, Params: declaration.Params, DeclarationScope: scope, Body: declaration.Body, TypedValue: TypedValue{Type: FunctionValueType}} // intializes with zero value for all fields
+func evalForStmt(stmt parser.ForStmt, scope *Scope) RuntimeValue {
+	// Each iteration gets its own copy of the variables declared in the header, made before the update runs
+	loopScope := NewScope(scope)
+
+	if stmt.Init != nil {
+		Evaluate(*stmt.Init, loopScope)
+	}
+
+	for stmt.Condition == nil || evalCondition(*stmt.Condition, loopScope) {
+		if keepGoing, signal := evalLoopBody(stmt.Body, loopScope); !keepGoing {
+			if signal != nil {
+				return signal
+			}
+			break
+		}
+
+		loopScope = loopScope.NextIteration()
+		if stmt.Update != nil {
+			Evaluate(*stmt.Update, loopScope)
+		}
+	}
 
-	return scope.DeclareVariable(function.Name, function, true)
+	return MakeNull()
 }
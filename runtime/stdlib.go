@@ -1,38 +1,314 @@
 package runtime
 
-import "fmt"
+import (
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+func Print(Args []RuntimeValue, scope *Scope) (RuntimeValue, error) {
+	var out io.Writer = os.Stdout
+	if stdout := scope.State().Stdout; stdout != nil {
+		out = stdout
+	}
 
-func Print(Args []RuntimeValue, scope *Scope) RuntimeValue {
 	for _, arg := range Args {
 		str := printRuntimeValue(arg)
-		fmt.Printf("%s ", str)
+		fmt.Fprintf(out, "%s ", str)
+	}
+	fmt.Fprintln(out)
+	return MakeNull(), nil
+}
+
+// Number of characters in a string, elements in an array or properties in an object
+func Len(Args []RuntimeValue, scope *Scope) (RuntimeValue, error) {
+	if err := expectArgs("len", Args, 1); err != nil {
+		return nil, err
+	}
+
+	switch arg := Args[0].(type) {
+	case StringValue:
+		return MakeInteger(int64(utf8.RuneCountInString(arg.Value))), nil
+	case ArrayValue:
+		return MakeInteger(int64(arg.Len())), nil
+	case ObjectValue:
+		return MakeInteger(int64(arg.Len())), nil
+	}
+	return nil, fmt.Errorf("len expects a string, array or object but got %s", Args[0].GetType())
+}
+
+// Array of the property names of an object, in insertion order
+func Keys(Args []RuntimeValue, scope *Scope) (RuntimeValue, error) {
+	if err := expectArgs("keys", Args, 1); err != nil {
+		return nil, err
+	}
+
+	obj, ok := Args[0].(ObjectValue)
+	if !ok {
+		return nil, fmt.Errorf("keys expects an object but got %s", Args[0].GetType())
+	}
+
+	keys := []RuntimeValue{}
+	for _, key := range obj.Keys() {
+		keys = append(keys, MakeString(key))
+	}
+	return MakeArray(keys), nil
+}
+
+// Appends the rest of the arguments to the array given first and returns its new length
+func Push(Args []RuntimeValue, scope *Scope) (RuntimeValue, error) {
+	if len(Args) == 0 {
+		return nil, fmt.Errorf("push expects an array and values to append")
+	}
+
+	arr, ok := Args[0].(ArrayValue)
+	if !ok {
+		return nil, fmt.Errorf("push expects an array but got %s", Args[0].GetType())
+	}
+
+	*arr.Elements = append(*arr.Elements, Args[1:]...)
+	return MakeInteger(int64(arr.Len())), nil
+}
+
+// New array of the results of calling a function with each element of an array and its index
+func Map(Args []RuntimeValue, scope *Scope) (RuntimeValue, error) {
+	results := []RuntimeValue{}
+	err := eachElement("map", Args, scope, func(element, result RuntimeValue) {
+		results = append(results, result)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return MakeArray(results), nil
+}
+
+// New array of the elements of an array that a function returns a truthy value for
+func Filter(Args []RuntimeValue, scope *Scope) (RuntimeValue, error) {
+	kept := []RuntimeValue{}
+	err := eachElement("filter", Args, scope, func(element, result RuntimeValue) {
+		if IsTruthy(result) {
+			kept = append(kept, element)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return MakeArray(kept), nil
+}
+
+// Calls a function with each element of an array and its index
+func ForEach(Args []RuntimeValue, scope *Scope) (RuntimeValue, error) {
+	return MakeNull(), eachElement("forEach", Args, scope, func(element, result RuntimeValue) {})
+}
+
+// Calls the function passed to the native name with each element of the array passed before it and the element's index,
+// then hands the element and the result to visit
+func eachElement(name string, Args []RuntimeValue, scope *Scope, visit func(element, result RuntimeValue)) error {
+	if err := expectArgs(name, Args, 2); err != nil {
+		return err
+	}
+
+	arr, ok := Args[0].(ArrayValue)
+	if !ok {
+		return fmt.Errorf("%s expects an array but got %s", name, Args[0].GetType())
+	}
+	fn := Args[1]
+	if fn.GetType() != FunctionValueType && fn.GetType() != InternalFunctionValueType {
+		return fmt.Errorf("%s expects a function but got %s", name, fn.GetType())
+	}
+
+	// Copy so that the function can push to the array without being called forever
+	elements := make([]RuntimeValue, arr.Len())
+	copy(elements, *arr.Elements)
+
+	for i, element := range elements {
+		args := []RuntimeValue{element, MakeInteger(int64(i))}
+		// QuonkScript functions must be called with exactly as many arguments as they have parameters
+		if function, ok := fn.(FunctionValue); ok && len(function.Params) < len(args) {
+			args = args[:len(function.Params)]
+		}
+
+		result, err := scope.CallFunction(fn, args)
+		if err != nil {
+			return err
+		}
+		visit(element, result)
+	}
+	return nil
+}
+
+// Converts a float or a string to an integer, floats are truncated towards zero
+func Int(Args []RuntimeValue, scope *Scope) (RuntimeValue, error) {
+	if err := expectArgs("int", Args, 1); err != nil {
+		return nil, err
+	}
+
+	switch arg := Args[0].(type) {
+	case IntegerValue:
+		return arg, nil
+	case NumberValue:
+		return floatToInteger(arg.Value)
+	case StringValue:
+		if num, err := strconv.ParseInt(arg.Value, 10, 64); err == nil {
+			return MakeInteger(num), nil
+		}
+		// Strings like "2.5" are truncated the same way floats are
+		num, err := strconv.ParseFloat(arg.Value, 64)
+		if err != nil {
+			return nil, fmt.Errorf("int cannot convert %s to an integer", strconv.Quote(arg.Value))
+		}
+		return floatToInteger(num)
+	}
+	return nil, fmt.Errorf("int expects a number or a string but got %s", Args[0].GetType())
+}
+
+func floatToInteger(num float64) (RuntimeValue, error) {
+	// float64(math.MaxInt64) rounds up to 2^63, which is already out of range
+	if math.IsNaN(num) || num < math.MinInt64 || num >= math.MaxInt64 {
+		return nil, fmt.Errorf("int cannot convert %v to an integer, it is out of range", num)
+	}
+	return MakeInteger(int64(num)), nil
+}
+
+// Converts an integer or a string to a float
+func Float(Args []RuntimeValue, scope *Scope) (RuntimeValue, error) {
+	if err := expectArgs("float", Args, 1); err != nil {
+		return nil, err
+	}
+
+	switch arg := Args[0].(type) {
+	case IntegerValue:
+		return MakeNumber(float64(arg.Value)), nil
+	case NumberValue:
+		return arg, nil
+	case StringValue:
+		num, err := strconv.ParseFloat(arg.Value, 64)
+		if err != nil {
+			return nil, fmt.Errorf("float cannot convert %s to a float", strconv.Quote(arg.Value))
+		}
+		return MakeNumber(num), nil
+	}
+	return nil, fmt.Errorf("float expects a number or a string but got %s", Args[0].GetType())
+}
+
+// Object holding the math functions and constants, declared as math
+func makeMathObject() ObjectValue {
+	obj := MakeObject()
+	obj.Set("pi", MakeNumber(math.Pi))
+	obj.Set("e", MakeNumber(math.E))
+
+	unary := map[string]func(float64) float64{
+		"sqrt":  math.Sqrt,
+		"abs":   math.Abs,
+		"floor": math.Floor,
+		"ceil":  math.Ceil,
+		"round": math.Round,
+		"sin":   math.Sin,
+		"cos":   math.Cos,
+		"tan":   math.Tan,
+		"log":   math.Log,
+	}
+	// Set in a fixed order, ranging over the map would make the object print differently every run
+	for _, name := range []string{"sqrt", "abs", "floor", "ceil", "round", "sin", "cos", "tan", "log"} {
+		fn := unary[name]
+		obj.Set(name, makeMathFunction("math."+name, 1, func(args []float64) float64 { return fn(args[0]) }))
+	}
+
+	obj.Set("pow", makeMathFunction("math.pow", 2, func(args []float64) float64 { return math.Pow(args[0], args[1]) }))
+	obj.Set("min", makeMathFunction("math.min", 2, func(args []float64) float64 { return math.Min(args[0], args[1]) }))
+	obj.Set("max", makeMathFunction("math.max", 2, func(args []float64) float64 { return math.Max(args[0], args[1]) }))
+
+	return obj
+}
+
+// Wraps a function of numbers, checking that it is called with arity numbers. Integer arguments are converted to floats
+// and the result is always a float
+func makeMathFunction(name string, arity int, fn func(args []float64) float64) InternalFunctionValue {
+	return MakeFunction(func(Args []RuntimeValue, scope *Scope) (RuntimeValue, error) {
+		if err := expectArgs(name, Args, arity); err != nil {
+			return nil, err
+		}
+
+		numbers := make([]float64, arity)
+		for i, arg := range Args {
+			number, ok := ToFloat(arg)
+			if !ok {
+				return nil, fmt.Errorf("%s expects numbers but argument %d is %s", name, i+1, arg.GetType())
+			}
+			numbers[i] = number
+		}
+		return MakeNumber(fn(numbers)), nil
+	})
+}
+
+func expectArgs(name string, args []RuntimeValue, count int) error {
+	if len(args) != count {
+		return fmt.Errorf("%s expects %d arguments but was called with %d", name, count, len(args))
 	}
-	fmt.Println()
-	return MakeNull()
+	return nil
 }
 
+// Formats a value the way print shows it
 func printRuntimeValue(val RuntimeValue) string {
+	return formatValue(val, nil)
+}
+
+// visiting holds the arrays and objects val is nested in. An array or object that contains itself
+// prints as [...] or {...} where it appears inside of itself, rather than recursing forever
+func formatValue(val RuntimeValue, visiting map[any]bool) string {
 	switch val.GetType() {
 	case NullValueType:
 		return "null"
 	case BooleanValueType:
 		return fmt.Sprintf("%t", val.(BooleanValue).GetValue())
 	case NumberValueType:
-		return fmt.Sprintf("%v", val.(NumberValue).GetValue())
+		return formatFloat(val.(NumberValue).GetValue())
+	case IntegerValueType:
+		return strconv.FormatInt(val.(IntegerValue).GetValue(), 10)
+	case StringValueType:
+		return val.(StringValue).GetValue()
 	case ObjectValueType:
 		obj := val.(ObjectValue)
+		if visiting[obj.Properties] {
+			return "{...}"
+		}
+		visiting = visit(visiting, obj.Properties)
+		defer delete(visiting, obj.Properties)
+
+		keys := obj.Keys() // in insertion order
 		asStr := "{"
-		for i, key := range obj.Keys() {
-			asStr += fmt.Sprintf("\"%s\": %s", key, printRuntimeValue(obj.Get(key)))
-			if i != len(obj.Keys())-1 {
+		for i, key := range keys {
+			asStr += fmt.Sprintf("\"%s\": %s", key, formatNestedValue(obj.Get(key), visiting))
+			if i != len(keys)-1 {
 				asStr += ", "
 			}
 		}
 		asStr += "}"
 		return asStr
-	// If the runtime val is a variable, call SprintfRuntimeValue recursively on Value field
+	case ArrayValueType:
+		arr := val.(ArrayValue)
+		if visiting[arr.Elements] {
+			return "[...]"
+		}
+		visiting = visit(visiting, arr.Elements)
+		defer delete(visiting, arr.Elements)
+
+		asStr := "["
+		for i := 0; i < arr.Len(); i++ {
+			asStr += formatNestedValue(arr.Get(i), visiting)
+			if i != arr.Len()-1 {
+				asStr += ", "
+			}
+		}
+		asStr += "]"
+		return asStr
+	// If the runtime val is a variable, format its Value field
 	case VariableValueType:
-		return printRuntimeValue(val.(VariableValue).GetValue())
+		return formatValue(val.(VariableValue).GetValue(), visiting)
 	case FunctionValueType:
 		function := val.(FunctionValue)
 		asStr := fmt.Sprintf("[Function: %s(", function.Name)
@@ -44,6 +320,35 @@ func printRuntimeValue(val RuntimeValue) string {
 		}
 		asStr += ")]"
 		return asStr
+	case InternalFunctionValueType:
+		return "[Function: native]"
 	}
 	return ""
 }
+
+// Whole floats keep a fractional part, so that 2.0 prints differently from the integer 2
+func formatFloat(num float64) string {
+	str := strconv.FormatFloat(num, 'g', -1, 64)
+	// Exponents, infinities and NaN are already clearly floats
+	if !strings.ContainsAny(str, ".eIN") {
+		str += ".0"
+	}
+	return str
+}
+
+// Marks the contents of an array or object as being printed, creating the set on first use
+func visit(visiting map[any]bool, contents any) map[any]bool {
+	if visiting == nil {
+		visiting = make(map[any]bool)
+	}
+	visiting[contents] = true
+	return visiting
+}
+
+// Formats a value that is inside of another value, strings are quoted so that they can be told apart from other values
+func formatNestedValue(val RuntimeValue, visiting map[any]bool) string {
+	if val.GetType() == StringValueType {
+		return strconv.Quote(val.(StringValue).GetValue())
+	}
+	return formatValue(val, visiting)
+}
@@ -1,19 +1,50 @@
 package runtime
 
-import "QuonkScript/parser"
+import (
+	"QuonkScript/lexer"
+	"QuonkScript/orderedmap"
+	"QuonkScript/parser"
+	"fmt"
+)
 
 type ValueType int
 
 const (
 	NullValueType ValueType = iota + 1
 	NumberValueType
+	IntegerValueType
 	BooleanValueType
 	ObjectValueType
 	InternalFunctionValueType
 	VariableValueType
 	FunctionValueType
+	StringValueType
+	ArrayValueType
+	ControlValueType // used internally for return, break and continue, never seen by programs
 )
 
+var valueTypeNames = map[ValueType]string{
+	NullValueType:             "null",
+	NumberValueType:           "float",
+	IntegerValueType:          "integer",
+	BooleanValueType:          "boolean",
+	ObjectValueType:           "object",
+	InternalFunctionValueType: "function",
+	VariableValueType:         "variable",
+	FunctionValueType:         "function",
+	StringValueType:           "string",
+	ArrayValueType:            "array",
+	ControlValueType:          "control",
+}
+
+// Name of the type as it should appear in error messages
+func (t ValueType) String() string {
+	if name, ok := valueTypeNames[t]; ok {
+		return name
+	}
+	return fmt.Sprintf("ValueType(%d)", int(t))
+}
+
 type RuntimeValue interface {
 	GetType() ValueType
 }
@@ -40,7 +71,9 @@ func MakeNull() NullValue {
 	return NullValue{TypedValue: TypedValue{Type: NullValueType}, Value: nil}
 }
 
-// Number
+// Numbers are either integers or floats. Arithmetic on two integers gives an integer, see BinaryOp
+
+// Float
 type NumberValue struct {
 	TypedValue // Type will be NumberValueType
 	Value      float64
@@ -58,6 +91,40 @@ func MakeNumber(n float64) NumberValue {
 	return NumberValue{TypedValue: TypedValue{Type: NumberValueType}, Value: n}
 }
 
+// Integer
+type IntegerValue struct {
+	TypedValue // Type will be IntegerValueType
+	Value      int64
+}
+
+func (i IntegerValue) GetType() ValueType {
+	return IntegerValueType
+}
+
+func (i IntegerValue) GetValue() int64 {
+	return i.Value
+}
+
+func MakeInteger(i int64) IntegerValue {
+	return IntegerValue{TypedValue: TypedValue{Type: IntegerValueType}, Value: i}
+}
+
+// Whether a value is an integer or a float
+func IsNumber(val RuntimeValue) bool {
+	return val.GetType() == IntegerValueType || val.GetType() == NumberValueType
+}
+
+// Value of an integer or a float as a float64, ok is false for every other value
+func ToFloat(val RuntimeValue) (f float64, ok bool) {
+	switch val := val.(type) {
+	case IntegerValue:
+		return float64(val.Value), true
+	case NumberValue:
+		return val.Value, true
+	}
+	return 0, false
+}
+
 // Boolean
 
 type BooleanValue struct {
@@ -77,6 +144,46 @@ func MakeBoolean(b bool) BooleanValue {
 	return BooleanValue{TypedValue: TypedValue{Type: BooleanValueType}, Value: b}
 }
 
+// String
+
+type StringValue struct {
+	TypedValue // Type will be StringValueType
+	Value      string
+}
+
+func (s StringValue) GetType() ValueType {
+	return StringValueType
+}
+
+func (s StringValue) GetValue() string {
+	return s.Value
+}
+
+func MakeString(s string) StringValue {
+	return StringValue{TypedValue: TypedValue{Type: StringValueType}, Value: s}
+}
+
+// Truthiness
+
+// Whether a value counts as true in conditions and logical operators.
+// null, false, 0, 0.0 and "" are falsy, every other value is truthy, including empty arrays and objects
+func IsTruthy(val RuntimeValue) bool {
+	switch val.GetType() {
+	case NullValueType:
+		return false
+	case BooleanValueType:
+		return val.(BooleanValue).Value
+	case NumberValueType:
+		return val.(NumberValue).Value != 0
+	case IntegerValueType:
+		return val.(IntegerValue).Value != 0
+	case StringValueType:
+		return val.(StringValue).Value != ""
+	default:
+		return true
+	}
+}
+
 // Variable
 
 type Variable interface {
@@ -117,44 +224,86 @@ type Object interface {
 	Keys() []string
 	Get(name string) RuntimeValue
 	Set(name string, value RuntimeValue) RuntimeValue
+	Delete(name string) bool
 }
 
 type ObjectValue struct {
 	TypedValue
-	Properties map[string]RuntimeValue
+	Properties *orderedmap.OrderedMap[RuntimeValue] // keeps insertion order so objects always print the same way
 }
 
 func (o ObjectValue) GetType() ValueType {
 	return o.Type
 }
 
+// Property names in the order they were first set
 func (o ObjectValue) Keys() []string {
-	keys := make([]string, 0)
+	return o.Properties.Keys()
+}
 
-	for k := range o.Properties {
-		keys = append(keys, k)
-	}
-	return keys
+func (o ObjectValue) Len() int {
+	return o.Properties.Len()
 }
 
+// Returns nil if the object has no such property
 func (o ObjectValue) Get(name string) RuntimeValue {
-	return o.Properties[name]
+	value, _ := o.Properties.Get(name)
+	return value
 }
 
+// Setting an existing property keeps its position in Keys
 func (o ObjectValue) Set(name string, value RuntimeValue) RuntimeValue {
-	o.Properties[name] = value
+	o.Properties.Set(name, value)
 
 	return value
 }
 
+// Removes a property, the remaining properties keep their order. Returns whether the property existed
+func (o ObjectValue) Delete(name string) bool {
+	return o.Properties.Delete(name)
+}
+
+func MakeObject() ObjectValue {
+	return ObjectValue{TypedValue: TypedValue{Type: ObjectValueType}, Properties: orderedmap.NewOrderedMap[RuntimeValue]()}
+}
+
+// Array
+
+type ArrayValue struct {
+	TypedValue
+	Elements *[]RuntimeValue // pointer so that every copy of the value refers to the same array
+}
+
+func (a ArrayValue) GetType() ValueType {
+	return ArrayValueType
+}
+
+func (a ArrayValue) Len() int {
+	return len(*a.Elements)
+}
+
+func (a ArrayValue) Get(index int) RuntimeValue {
+	return (*a.Elements)[index]
+}
+
+func (a ArrayValue) Set(index int, value RuntimeValue) RuntimeValue {
+	(*a.Elements)[index] = value
+
+	return value
+}
+
+func MakeArray(elements []RuntimeValue) ArrayValue {
+	return ArrayValue{TypedValue: TypedValue{Type: ArrayValueType}, Elements: &elements}
+}
+
 // Functions (I am not going to distinguish from native and user defined functions)
 
-// This is cool
-type InternalFunctionCall func(Args []RuntimeValue, scope *Scope) RuntimeValue
+// This is cool. A returned error is raised at the call site, a nil result is treated as null
+type InternalFunctionCall func(Args []RuntimeValue, scope *Scope) (RuntimeValue, error)
 
 type InternalFunction interface {
 	RuntimeValue
-	Call(Args []RuntimeValue, scope *Scope) RuntimeValue
+	Call(Args []RuntimeValue, scope *Scope) (RuntimeValue, error)
 }
 
 type InternalFunctionValue struct {
@@ -166,6 +315,9 @@ func (f InternalFunctionValue) GetType() ValueType {
 	return InternalFunctionValueType
 }
 
+// Name of native functions in tracebacks
+const NativeFunctionName = "<native>"
+
 func MakeFunction(call InternalFunctionCall) InternalFunctionValue {
 	return InternalFunctionValue{TypedValue: TypedValue{Type: InternalFunctionValueType}, Func: call}
 }
@@ -174,14 +326,35 @@ type Function interface {
 	RuntimeValue
 }
 
+// Name given to functions created by function expressions, it cannot clash with a declared function
+const AnonymousFunctionName = "<anonymous>"
+
 type FunctionValue struct {
 	TypedValue
 	Name             string
 	Params           []string
-	DeclarationScope *Scope
+	DeclarationScope *Scope // the scope the function closes over
 	Body             []parser.Stmt
+	Slots            int          // variables in the scope of a call, parameters are declared by name if it is 0
+	Compiled         CompiledCode // set when Body was compiled to bytecode, calls run it instead of evaluating Body
 }
 
 func (f FunctionValue) GetType() ValueType {
 	return FunctionValueType
 }
+
+// Runs functions compiled by the vm package, so that natives and Go can call them like any other function
+type CompiledCode interface {
+	// Calls fn, which is called from callSite. Errors are returned as a *RuntimeError
+	Call(fn FunctionValue, args []RuntimeValue, scope *Scope, callSite lexer.Position) (RuntimeValue, error)
+}
+
+// const double = x => x * 2 names the function double, which makes tracebacks readable.
+// Returns value unchanged unless it is an anonymous function
+func NameFunction(value RuntimeValue, name string) RuntimeValue {
+	if function, ok := value.(FunctionValue); ok && function.Name == AnonymousFunctionName {
+		function.Name = name
+		return function
+	}
+	return value
+}
@@ -0,0 +1,113 @@
+package vm
+
+import (
+	"QuonkScript/compiler"
+	"QuonkScript/runtime"
+)
+
+var binaryOperators = map[compiler.Opcode]string{
+	compiler.OpAdd:      "+",
+	compiler.OpSubtract: "-",
+	compiler.OpMultiply: "*",
+	compiler.OpDivide:   "/",
+	compiler.OpModulo:   "%",
+}
+
+var comparisonOperators = map[compiler.Opcode]string{
+	compiler.OpEqual:        "==",
+	compiler.OpNotEqual:     "!=",
+	compiler.OpLess:         "<",
+	compiler.OpLessEqual:    "<=",
+	compiler.OpGreater:      ">",
+	compiler.OpGreaterEqual: ">=",
+}
+
+var unaryOperators = map[compiler.Opcode]string{
+	compiler.OpNegate: "-",
+	compiler.OpPlus:   "+",
+	compiler.OpNot:    "!",
+}
+
+// Arithmetic on two integers or two floats is done here, everything else is left to runtime.BinaryOp.
+// Integer results that overflow are also left to it, so that it raises the error
+func binaryOp(op compiler.Opcode, left runtime.RuntimeValue, right runtime.RuntimeValue) (runtime.RuntimeValue, error) {
+	if l, ok := left.(runtime.IntegerValue); ok {
+		if r, ok := right.(runtime.IntegerValue); ok {
+			switch op {
+			case compiler.OpAdd:
+				if sum := l.Value + r.Value; (sum > l.Value) == (r.Value > 0) {
+					return runtime.MakeInteger(sum), nil
+				}
+			case compiler.OpSubtract:
+				if difference := l.Value - r.Value; (difference < l.Value) == (r.Value > 0) {
+					return runtime.MakeInteger(difference), nil
+				}
+			}
+			return runtime.BinaryOp(binaryOperators[op], left, right)
+		}
+	}
+
+	l, lok := left.(runtime.NumberValue)
+	r, rok := right.(runtime.NumberValue)
+	if lok && rok {
+		switch op {
+		case compiler.OpAdd:
+			return runtime.MakeNumber(l.Value + r.Value), nil
+		case compiler.OpSubtract:
+			return runtime.MakeNumber(l.Value - r.Value), nil
+		case compiler.OpMultiply:
+			return runtime.MakeNumber(l.Value * r.Value), nil
+		case compiler.OpDivide:
+			return runtime.MakeNumber(l.Value / r.Value), nil
+		}
+	}
+	return runtime.BinaryOp(binaryOperators[op], left, right)
+}
+
+// Comparisons of integers and floats are done here, everything else is left to runtime.CompareOp.
+// An integer and a float are compared exactly with runtime.CompareIntegerFloat, like runtime.CompareOp does
+func compareOp(op compiler.Opcode, left runtime.RuntimeValue, right runtime.RuntimeValue) (runtime.RuntimeValue, error) {
+	switch l := left.(type) {
+	case runtime.IntegerValue:
+		switch r := right.(type) {
+		case runtime.IntegerValue:
+			return compare(op, l.Value, r.Value), nil
+		case runtime.NumberValue:
+			order, ok := runtime.CompareIntegerFloat(l.Value, r.Value)
+			return compareOrder(op, order, ok), nil
+		}
+	case runtime.NumberValue:
+		switch r := right.(type) {
+		case runtime.IntegerValue:
+			order, ok := runtime.CompareIntegerFloat(r.Value, l.Value)
+			return compareOrder(op, -order, ok), nil
+		case runtime.NumberValue:
+			return compare(op, l.Value, r.Value), nil
+		}
+	}
+	return runtime.CompareOp(comparisonOperators[op], left, right)
+}
+
+// Applies op to the order of two numbers, -1, 0 or 1. ok is false if one of them is NaN, which is only unequal
+func compareOrder(op compiler.Opcode, order int, ok bool) runtime.RuntimeValue {
+	if !ok {
+		return runtime.MakeBoolean(op == compiler.OpNotEqual)
+	}
+	return compare(op, int64(order), 0)
+}
+
+func compare[T int64 | float64](op compiler.Opcode, l T, r T) runtime.RuntimeValue {
+	switch op {
+	case compiler.OpEqual:
+		return runtime.MakeBoolean(l == r)
+	case compiler.OpNotEqual:
+		return runtime.MakeBoolean(l != r)
+	case compiler.OpLess:
+		return runtime.MakeBoolean(l < r)
+	case compiler.OpLessEqual:
+		return runtime.MakeBoolean(l <= r)
+	case compiler.OpGreater:
+		return runtime.MakeBoolean(l > r)
+	}
+	return runtime.MakeBoolean(l >= r) // OpGreaterEqual
+}
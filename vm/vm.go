@@ -0,0 +1,346 @@
+// Package vm runs programs compiled by the compiler package. It uses the values, scopes and operators of the runtime
+// package, so programs behave the same as when they are evaluated by runtime.Run, only faster
+package vm
+
+import (
+	"QuonkScript/compiler"
+	"QuonkScript/lexer"
+	"QuonkScript/runtime"
+	"context"
+	"fmt"
+)
+
+// A call being run by the machine
+type frame struct {
+	function *compiler.Function
+	ip       int            // offset of the next instruction
+	env      *runtime.Scope // innermost scope, block scopes are pushed on top of the scope of the call
+	base     int            // height of the stack when the call was made, the result replaces everything above it
+	call     bool           // whether the call has a runtime.Frame, the program that was run does not
+}
+
+type machine struct {
+	state       *runtime.State
+	stack       []runtime.RuntimeValue
+	frames      []frame
+	entryFrames int // length of state.Frames when the machine was started
+}
+
+// Runs a compiled program in scope and returns the value of its last statement.
+// Errors are returned as a *runtime.RuntimeError, the same way as runtime.Run
+func Run(function *compiler.Function, scope *runtime.Scope) (runtime.RuntimeValue, error) {
+	return RunContext(context.Background(), function, scope)
+}
+
+// Like Run, but stopped once ctx is done the same way as runtime.RunContext
+func RunContext(ctx context.Context, function *compiler.Function, scope *runtime.Scope) (runtime.RuntimeValue, error) {
+	state := scope.State()
+	defer state.StartBudget(ctx)()
+
+	m := &machine{state: state, entryFrames: len(state.Frames)}
+	m.frames = append(m.frames, frame{function: function, env: scope})
+	return m.run()
+}
+
+// Function code that runs on a new machine when it is called by a native or from Go
+type code compiler.Function
+
+func (c *code) Call(fn runtime.FunctionValue, args []runtime.RuntimeValue, scope *runtime.Scope, callSite lexer.Position) (runtime.RuntimeValue, error) {
+	m := &machine{state: scope.State(), entryFrames: len(scope.State().Frames)}
+	if err := m.enter(fn, args, callSite, false); err != nil {
+		return nil, err
+	}
+	return m.run()
+}
+
+// Runs until the first frame returns
+func (m *machine) run() (result runtime.RuntimeValue, err error) {
+	defer func() {
+		if err != nil {
+			// Drop the frames of the calls that were aborted, like runtime.Run does
+			m.state.Frames = m.state.Frames[:m.entryFrames]
+			result = runtime.MakeNull()
+		}
+	}()
+
+	f := &m.frames[len(m.frames)-1]
+	for {
+		if err := m.state.Step(); err != nil {
+			return nil, m.fail(f, f.ip, err)
+		}
+
+		start := f.ip
+		op := compiler.Opcode(f.function.Code[f.ip])
+		f.ip++
+
+		switch op {
+		case compiler.OpConstant:
+			m.push(f.function.Constants[m.readOperand(f, 2)])
+		case compiler.OpNull:
+			m.push(runtime.MakeNull())
+		case compiler.OpTrue:
+			m.push(runtime.MakeBoolean(true))
+		case compiler.OpFalse:
+			m.push(runtime.MakeBoolean(false))
+		case compiler.OpPop:
+			m.pop()
+
+		case compiler.OpGetVar:
+			value, err := f.env.LookupVariable(f.function.Names[m.readOperand(f, 2)])
+			if err != nil {
+				return nil, m.fail(f, start, err)
+			}
+			m.push(value)
+		case compiler.OpDeclareVar:
+			name := f.function.Names[m.readOperand(f, 2)]
+			constant := m.readOperand(f, 1) == 1
+			value, err := f.env.DeclareVariable(name, runtime.NameFunction(m.pop(), name), constant)
+			if err != nil {
+				return nil, m.fail(f, start, err)
+			}
+			m.push(value)
+		case compiler.OpAssignVar:
+			value, err := f.env.AssignVariable(f.function.Names[m.readOperand(f, 2)], m.pop())
+			if err != nil {
+				return nil, m.fail(f, start, err)
+			}
+			m.push(value)
+
+		case compiler.OpGetLocal:
+			depth, slot := m.readOperand(f, 1), m.readOperand(f, 2)
+			value, err := f.env.LookupSlot(depth, slot, f.function.Names[m.readOperand(f, 2)])
+			if err != nil {
+				return nil, m.fail(f, start, err)
+			}
+			m.push(value)
+		case compiler.OpDeclareLocal:
+			slot, name := m.readOperand(f, 2), f.function.Names[m.readOperand(f, 2)]
+			m.push(f.env.DeclareSlot(slot, runtime.NameFunction(m.pop(), name)))
+		case compiler.OpSetLocal:
+			depth, slot := m.readOperand(f, 1), m.readOperand(f, 2)
+			value, err := f.env.AssignSlot(depth, slot, f.function.Names[m.readOperand(f, 2)], m.pop())
+			if err != nil {
+				return nil, m.fail(f, start, err)
+			}
+			m.push(value)
+
+		case compiler.OpAdd, compiler.OpSubtract, compiler.OpMultiply, compiler.OpDivide, compiler.OpModulo:
+			right, left := m.pop(), m.pop()
+			result, err := binaryOp(op, left, right)
+			if err != nil {
+				return nil, m.fail(f, start, err)
+			}
+			m.push(result)
+		case compiler.OpEqual, compiler.OpNotEqual, compiler.OpLess, compiler.OpLessEqual, compiler.OpGreater, compiler.OpGreaterEqual:
+			right, left := m.pop(), m.pop()
+			result, err := compareOp(op, left, right)
+			if err != nil {
+				return nil, m.fail(f, start, err)
+			}
+			m.push(result)
+		case compiler.OpNegate, compiler.OpPlus, compiler.OpNot:
+			result, err := runtime.UnaryOp(unaryOperators[op], m.pop())
+			if err != nil {
+				return nil, m.fail(f, start, err)
+			}
+			m.push(result)
+
+		case compiler.OpJump:
+			f.ip = m.readOperand(f, 2)
+		case compiler.OpJumpIfFalse:
+			target := m.readOperand(f, 2)
+			if !runtime.IsTruthy(m.pop()) {
+				f.ip = target
+			}
+		case compiler.OpJumpIfFalseOrPop:
+			target := m.readOperand(f, 2)
+			if !runtime.IsTruthy(m.peek()) {
+				f.ip = target
+			} else {
+				m.pop()
+			}
+		case compiler.OpJumpIfTrueOrPop:
+			target := m.readOperand(f, 2)
+			if runtime.IsTruthy(m.peek()) {
+				f.ip = target
+			} else {
+				m.pop()
+			}
+
+		case compiler.OpPushScope:
+			f.env = runtime.NewScope(f.env)
+		case compiler.OpPopScope:
+			f.env = f.env.Parent
+		case compiler.OpNextIteration:
+			f.env = f.env.NextIteration()
+
+		case compiler.OpArray:
+			count := m.readOperand(f, 2)
+			elements := make([]runtime.RuntimeValue, count)
+			copy(elements, m.stack[len(m.stack)-count:])
+			m.stack = m.stack[:len(m.stack)-count]
+			m.push(runtime.MakeArray(elements))
+		case compiler.OpObject:
+			m.push(runtime.MakeObject())
+		case compiler.OpSetProperty:
+			value := m.pop()
+			m.peek().(runtime.ObjectValue).Set(f.function.Names[m.readOperand(f, 2)], value)
+
+		case compiler.OpCheckMember:
+			if err := runtime.CheckMemberTarget(m.peek(), m.readOperand(f, 1) == 1); err != nil {
+				return nil, m.fail(f, start, err)
+			}
+		case compiler.OpCheckKey:
+			key, obj := m.stack[len(m.stack)-1], m.stack[len(m.stack)-2]
+			if err := runtime.CheckMemberKey(obj, key, m.readOperand(f, 1) == 1); err != nil {
+				return nil, m.fail(f, start, err)
+			}
+		case compiler.OpGetMember:
+			key, obj := m.pop(), m.pop()
+			value, err := runtime.GetMember(obj, key, m.readOperand(f, 1) == 1)
+			if err != nil {
+				return nil, m.fail(f, start, err)
+			}
+			m.push(value)
+		case compiler.OpSetMember:
+			value, key, obj := m.pop(), m.pop(), m.pop()
+			value, err := runtime.SetMember(obj, key, m.readOperand(f, 1) == 1, value)
+			if err != nil {
+				return nil, m.fail(f, start, err)
+			}
+			m.push(value)
+
+		case compiler.OpClosure:
+			function := f.function.Functions[m.readOperand(f, 2)]
+			m.push(runtime.FunctionValue{
+				TypedValue:       runtime.TypedValue{Type: runtime.FunctionValueType},
+				Name:             function.Name,
+				Params:           function.Params,
+				Slots:            function.Slots,
+				DeclarationScope: f.env,
+				Body:             function.Body,
+				Compiled:         (*code)(function),
+			})
+
+		case compiler.OpCall, compiler.OpTailCall:
+			argc := m.readOperand(f, 1)
+			fn := m.pop()
+			args := m.stack[len(m.stack)-argc:]
+			m.stack = m.stack[:len(m.stack)-argc]
+
+			pos := f.function.PositionAt(start)
+			callSite, env := pos, f.env
+			if op == compiler.OpTailCall {
+				// The call takes over the frame of the call that is finishing, keeping its call site
+				callSite = m.state.Frames[len(m.state.Frames)-1].CallSite
+				m.dropFrame()
+			}
+
+			if function, ok := fn.(runtime.FunctionValue); ok && isCompiled(function) {
+				if err := m.enter(function, args, callSite, op == compiler.OpTailCall); err != nil {
+					return nil, m.state.Error(pos, err)
+				}
+			} else {
+				result, err := m.callValue(fn, args, env, callSite)
+				if err != nil {
+					return nil, m.state.Error(pos, err)
+				}
+				if len(m.frames) == 0 {
+					// A tail call made by the call the machine was started for
+					return result, nil
+				}
+				m.push(result)
+			}
+			f = &m.frames[len(m.frames)-1]
+
+		case compiler.OpReturn:
+			if done, result := m.leave(); done {
+				return result, nil
+			}
+			f = &m.frames[len(m.frames)-1]
+
+		case compiler.OpError:
+			return nil, m.fail(f, start, fmt.Errorf("%s", f.function.Names[m.readOperand(f, 2)]))
+
+		default:
+			return nil, m.fail(f, start, fmt.Errorf("Unknown opcode %s", op))
+		}
+	}
+}
+
+func isCompiled(fn runtime.FunctionValue) bool {
+	_, ok := fn.Compiled.(*code)
+	return ok
+}
+
+// Pushes a frame for a call to a compiled function, args are copied into the scope of the call
+func (m *machine) enter(fn runtime.FunctionValue, args []runtime.RuntimeValue, callSite lexer.Position, tail bool) error {
+	// A tail call reuses the depth of the call it replaces
+	env, err := m.state.EnterCall(fn, args, callSite, tail)
+	if err != nil {
+		return err
+	}
+	m.frames = append(m.frames, frame{function: (*compiler.Function)(fn.Compiled.(*code)), env: env, base: len(m.stack), call: true})
+	return nil
+}
+
+// Pops the current frame, pushing its result for the caller. Returns true and the result if it was the last frame
+func (m *machine) leave() (bool, runtime.RuntimeValue) {
+	result := m.pop()
+	m.dropFrame()
+
+	if len(m.frames) == 0 {
+		return true, result
+	}
+	m.push(result)
+	return false, nil
+}
+
+// Pops the current frame and everything it left on the stack
+func (m *machine) dropFrame() {
+	f := m.frames[len(m.frames)-1]
+	m.stack = m.stack[:f.base]
+	m.frames = m.frames[:len(m.frames)-1]
+	if f.call {
+		m.state.Frames = m.state.Frames[:len(m.state.Frames)-1]
+	}
+}
+
+// Calls a native, or a function that was not compiled by going through the runtime
+func (m *machine) callValue(fn runtime.RuntimeValue, args []runtime.RuntimeValue, env *runtime.Scope, callSite lexer.Position) (runtime.RuntimeValue, error) {
+	// The stack is reused, so natives get their own copy of the arguments
+	args = append([]runtime.RuntimeValue(nil), args...)
+
+	switch fn := fn.(type) {
+	case runtime.InternalFunctionValue:
+		return runtime.CallNative(fn, args, env, callSite)
+	case runtime.FunctionValue:
+		return runtime.Call(fn, args, env)
+	}
+	return nil, fmt.Errorf("Cannot call non-function value of type %s", fn.GetType())
+}
+
+// Turns err into a RuntimeError at the instruction at offset in f
+func (m *machine) fail(f *frame, offset int, err error) error {
+	return m.state.Error(f.function.PositionAt(offset), err)
+}
+
+func (m *machine) readOperand(f *frame, width int) int {
+	operand := compiler.ReadOperand(f.function.Code, f.ip, width)
+	f.ip += width
+	return operand
+}
+
+func (m *machine) push(value runtime.RuntimeValue) {
+	m.stack = append(m.stack, value)
+}
+
+func (m *machine) pop() runtime.RuntimeValue {
+	value := m.stack[len(m.stack)-1]
+	m.stack = m.stack[:len(m.stack)-1]
+	return value
+}
+
+func (m *machine) peek() runtime.RuntimeValue {
+	return m.stack[len(m.stack)-1]
+}